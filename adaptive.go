@@ -0,0 +1,56 @@
+package zkv
+
+import "time"
+
+// adaptiveBuffer tracks write rate to adjust memory buffer flush threshold
+type adaptiveBuffer struct {
+	threshold int
+	lastWrite time.Time
+	lastFlush time.Time
+}
+
+// flushIfFull flushes memory buffer when it exceeds flush threshold
+func (s *Store) flushIfFull() error {
+	if s.options.MaxBufferedKeys > 0 && len(s.bufferDataOffset) > s.options.MaxBufferedKeys {
+		return s.flush()
+	}
+
+	a := s.adaptiveBuffer
+	if a == nil {
+		if s.buffer.Len() > s.options.MemoryBufferSize {
+			return s.flush()
+		}
+
+		return nil
+	}
+
+	now := time.Now()
+
+	// Shrink threshold on write after idle period
+	if !a.lastWrite.IsZero() && now.Sub(a.lastWrite) > s.options.AdaptiveFlushInterval {
+		a.threshold /= 2
+		if a.threshold < s.options.MemoryBufferSize {
+			a.threshold = s.options.MemoryBufferSize
+		}
+	}
+	a.lastWrite = now
+
+	if s.buffer.Len() <= a.threshold {
+		return nil
+	}
+
+	// Grow threshold instead of flush when buffer fills faster
+	// than target flush interval
+	if now.Sub(a.lastFlush) < s.options.AdaptiveFlushInterval && a.threshold < s.options.AdaptiveBufferMaxSize {
+		a.threshold *= 2
+		if a.threshold > s.options.AdaptiveBufferMaxSize {
+			a.threshold = s.options.AdaptiveBufferMaxSize
+		}
+
+		return nil
+	}
+
+	a.lastFlush = now
+
+	return s.flush()
+}
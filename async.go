@@ -0,0 +1,148 @@
+package zkv
+
+// Capacity of AsyncWrites mode queue
+const writeQueueSize = 1024
+
+func (s *Store) startWriter() {
+	s.writeQueue = make(chan func() error, writeQueueSize)
+	s.writerDone = make(chan struct{})
+
+	go s.runWriter()
+}
+
+// runWriter applies queued operations, all operations queued
+// at the moment are applied under single lock
+func (s *Store) runWriter() {
+	defer close(s.writerDone)
+
+	for op := range s.writeQueue {
+		s.mu.Lock()
+		s.applyWrite(op)
+
+	drain:
+		for {
+			select {
+			case op, ok := <-s.writeQueue:
+				if !ok {
+					break drain
+				}
+				s.applyWrite(op)
+			default:
+				break drain
+			}
+		}
+
+		s.mu.Unlock()
+	}
+}
+
+// applyWrite runs queued operation and keeps its error.
+// Must be called under store lock.
+func (s *Store) applyWrite(op func() error) {
+	err := op()
+	if err != nil {
+		s.reportAsyncErr(err)
+	}
+}
+
+// reportAsyncErr keeps error of background operation to be returned by
+// Sync or Close and sends it to Errors channel. Must be called under
+// store lock.
+func (s *Store) reportAsyncErr(err error) {
+	if s.asyncErr == nil {
+		s.asyncErr = err
+	}
+
+	s.sendError(err)
+}
+
+// sendError sends error of background operation to Errors channel.
+// Must be called under store lock.
+func (s *Store) sendError(err error) {
+	if s.options.BlockOnErrors {
+		s.errorsChan <- err
+		return
+	}
+
+	select {
+	case s.errorsChan <- err:
+	default:
+	}
+}
+
+// Errors returns channel of errors of queued writes of AsyncWrites mode,
+// of expired keys reaper, of stopped tails of TailTo and ErrCorruptIndex
+// of index file rebuilt on open. Channel has ErrorsBufferSize capacity,
+// errors are dropped while it is full unless BlockOnErrors option is set.
+// Channel is closed on Close.
+func (s *Store) Errors() <-chan error {
+	return s.errorsChan
+}
+
+// enqueueWrite adds operation to queue, blocks while queue is full
+func (s *Store) enqueueWrite(op func() error) error {
+	s.writeQueueMu.RLock()
+	defer s.writeQueueMu.RUnlock()
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	if s.writeQueueClosed {
+		return ErrClosed
+	}
+
+	s.writeQueue <- op
+
+	return nil
+}
+
+// stopWriter waits for queued operations and stops writer
+func (s *Store) stopWriter() {
+	s.writeQueueMu.Lock()
+	if !s.writeQueueClosed {
+		s.writeQueueClosed = true
+		close(s.writeQueue)
+	}
+	s.writeQueueMu.Unlock()
+
+	<-s.writerDone
+}
+
+// Sync waits for operations queued in AsyncWrites mode and flushes data
+// to disk. Returns first error of queued operations since previous Sync.
+func (s *Store) Sync() error {
+	if s.writeQueue != nil {
+		done := make(chan struct{})
+
+		err := s.enqueueWrite(func() error {
+			close(done)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		<-done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	err := s.flush()
+
+	if s.asyncErr != nil {
+		err = s.asyncErr
+		s.asyncErr = nil
+	}
+
+	return err
+}
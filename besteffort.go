@@ -0,0 +1,117 @@
+package zkv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// SkippedRange is damaged range of store file skipped by index rebuild
+// of BestEffortIndex option
+type SkippedRange struct {
+	Offset int64
+	Size   int64
+
+	// Error of first damaged block of range
+	Err error
+}
+
+// SkippedRanges returns damaged ranges of store file skipped by the latest
+// index rebuild. Records of skipped ranges are not indexed.
+func (s *Store) SkippedRanges() []SkippedRange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]SkippedRange(nil), s.skippedRanges...)
+}
+
+// Size of chunks of store file searched for next block
+const blockSearchChunkSize = 64 * 1024
+
+// findBlock returns offset of first readable block starting after given
+// offset or fileSize if there is no such block. Blocks are searched by
+// zstd magic number following block header, so uncompressed blocks
+// can not be found.
+func (s *Store) findBlock(offset, fileSize int64) (int64, error) {
+	if s.version < formatVersionBlockHeader {
+		return fileSize, nil
+	}
+
+	f, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	headerSize := int64(blockHeaderSize(s.version))
+
+	searchOffset := offset + 1 + headerSize
+	_, err = f.Seek(searchOffset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	// Chunks overlap to find magic number split between them
+	chunk := make([]byte, blockSearchChunkSize)
+	for {
+		n, err := io.ReadFull(f, chunk)
+		if err == io.EOF {
+			return fileSize, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		for i := 0; i+len(zstdMagic) <= n; {
+			j := bytes.Index(chunk[i:n], zstdMagic)
+			if j < 0 {
+				break
+			}
+
+			candidate := searchOffset + int64(i+j) - headerSize
+			valid, err := s.isBlockAt(candidate)
+			if err != nil {
+				return 0, err
+			}
+			if valid {
+				return candidate, nil
+			}
+
+			i += j + 1
+		}
+
+		if n < len(chunk) {
+			return fileSize, nil
+		}
+
+		searchOffset += int64(n - len(zstdMagic) + 1)
+		_, err = f.Seek(searchOffset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// isBlockAt reports whether readable block starts at given offset
+func (s *Store) isBlockAt(offset int64) (bool, error) {
+	f, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return false, err
+	}
+
+	dec, err := s.options.DecoderPool.get(nil)
+	if err != nil {
+		return false, err
+	}
+	defer s.options.DecoderPool.put(dec)
+
+	_, _, err = s.readBlockData(bufio.NewReader(f), dec, 0)
+
+	return err == nil, nil
+}
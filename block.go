@@ -0,0 +1,282 @@
+package zkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// blockHeader precedes every block of files of formatVersionBlockHeader
+// and later versions
+type blockHeader struct {
+	// Block flags, since formatVersionBlockFlags
+	Flags blockFlags
+
+	// Size of compressed block data following header
+	CompressedSize uint64
+
+	// Size of block data after decompression
+	UncompressedSize uint64
+}
+
+type blockFlags uint8
+
+const (
+	// Block data is stored without compression
+	blockFlagUncompressed blockFlags = 1 << iota
+)
+
+// Upper limit of read buffer preallocated from block header
+const maxBlockPreallocSize = 256 * 1024 * 1024
+
+// Upper limit of decompressed block size of ReadBlockAt
+const maxReadBlockSize = 1024 * 1024 * 1024
+
+// blockHeaderSize returns size of block header of store file format version
+func blockHeaderSize(version byte) int {
+	if version >= formatVersionBlockFlags {
+		return 17
+	}
+
+	return 16
+}
+
+func (h blockHeader) marshal(version byte) []byte {
+	b := make([]byte, blockHeaderSize(version))
+
+	sizes := b
+	if version >= formatVersionBlockFlags {
+		b[0] = byte(h.Flags)
+		sizes = b[1:]
+	}
+
+	binary.LittleEndian.PutUint64(sizes, h.CompressedSize)
+	binary.LittleEndian.PutUint64(sizes[8:], h.UncompressedSize)
+
+	return b
+}
+
+// readBlockHeader reads block header, returns io.EOF on end of file
+func readBlockHeader(r io.Reader, version byte) (blockHeader, error) {
+	b := make([]byte, blockHeaderSize(version))
+
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return blockHeader{}, err
+	}
+
+	var header blockHeader
+	if version >= formatVersionBlockFlags {
+		header.Flags = blockFlags(b[0])
+		b = b[1:]
+	}
+
+	header.CompressedSize = binary.LittleEndian.Uint64(b)
+	header.UncompressedSize = binary.LittleEndian.Uint64(b[8:])
+
+	return header, nil
+}
+
+// readBlockData reads next block of store file and returns its
+// decompressed data and size in store file, returns io.EOF on end of file.
+// Size is returned on decompression error too if block was read. Blocks
+// which header sizes exceed non-zero maxSize are not read.
+func (s *Store) readBlockData(r *bufio.Reader, dec *zstd.Decoder, maxSize uint64) (data []byte, n int64, err error) {
+	if s.version < formatVersionBlockHeader {
+		l, n, err := readBlock(r)
+		if err != nil && (err != io.EOF || len(l) == 0) {
+			return nil, 0, err
+		}
+
+		data, err = dec.DecodeAll(l, nil)
+		if err != nil {
+			return nil, int64(n), err
+		}
+
+		return data, int64(n), nil
+	}
+
+	header, err := readBlockHeader(r, s.version)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Uncompressed blocks are not limited by decoder memory limit
+	if maxSize > 0 && (header.CompressedSize > maxSize || header.UncompressedSize > maxSize) {
+		return nil, 0, fmt.Errorf("%w: block size %d exceeds limit %d", ErrCorruptBlock, header.UncompressedSize, maxSize)
+	}
+
+	// Read through limit reader to not trust size of possibly corrupted header
+	compressed, err := io.ReadAll(io.LimitReader(r, int64(header.CompressedSize)))
+	if err != nil {
+		return nil, 0, err
+	}
+	if uint64(len(compressed)) != header.CompressedSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	n = int64(blockHeaderSize(s.version)) + int64(header.CompressedSize)
+
+	if header.Flags&blockFlagUncompressed != 0 {
+		return compressed, n, nil
+	}
+
+	preallocSize := header.UncompressedSize
+	if preallocSize > maxBlockPreallocSize {
+		preallocSize = maxBlockPreallocSize
+	}
+
+	data, err = dec.DecodeAll(compressed, make([]byte, 0, preallocSize))
+	if err != nil {
+		return nil, n, err
+	}
+	if uint64(len(data)) != header.UncompressedSize {
+		return nil, n, fmt.Errorf("%w: decompressed size %d differs from header size %d", ErrCorruptBlock, len(data), header.UncompressedSize)
+	}
+
+	return data, n, nil
+}
+
+// BlockInfo describes block of store file
+type BlockInfo struct {
+	// Offset of block in store file
+	Offset int64
+
+	// Size of block in store file including block header
+	Size int64
+
+	// Number of records of all types in block
+	RecordCount int
+}
+
+// Blocks returns blocks of store file in file order. Store file is
+// fully scanned, unflushed records are not counted.
+func (s *Store) Blocks() ([]BlockInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	stat, err := s.options.Storage.Stat(s.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrStoreStat, err)
+	}
+
+	var blocks []BlockInfo
+	err = s.scanRecords(func(offsets Offsets, record *Record) error {
+		if len(blocks) == 0 || blocks[len(blocks)-1].Offset != offsets.BlockOffset {
+			if len(blocks) > 0 {
+				last := &blocks[len(blocks)-1]
+				last.Size = offsets.BlockOffset - last.Offset
+			}
+
+			blocks = append(blocks, BlockInfo{Offset: offsets.BlockOffset})
+		}
+		blocks[len(blocks)-1].RecordCount++
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blocks) > 0 {
+		last := &blocks[len(blocks)-1]
+		last.Size = stat.Size() - last.Offset
+	}
+
+	return blocks, nil
+}
+
+// ReadBlockAt reads all records of store file block at given offset as
+// returned by Blocks. Decompressed block size is limited to 1 GiB.
+// Unflushed records are not read.
+func (s *Store) ReadBlockAt(blockOffset int64) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	if blockOffset < int64(len(fileHeader(s.version, s.keyHashSize))) {
+		return nil, fmt.Errorf("block offset %d is inside file header", blockOffset)
+	}
+
+	s.readOrderChan <- struct{}{}
+	defer func() { <-s.readOrderChan }()
+
+	f, err := s.acquireFile()
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseFile(f)
+
+	_, err = f.Seek(blockOffset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxReadBlockSize))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	data, _, err := s.readBlockData(bufio.NewReader(f), dec, maxReadBlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("block at offset %d: %w", blockOffset, err)
+	}
+
+	var records []*Record
+
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		_, record, err := readRecord(reader)
+		if err != nil {
+			return nil, fmt.Errorf("block at offset %d: %w", blockOffset, err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// RecordAt reads record of store file at given block offset and record
+// offset inside decompressed block as stored in Offsets of index
+func (s *Store) RecordAt(blockOffset, recordOffset int64) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	stat, err := s.options.Storage.Stat(s.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStoreStat, err)
+	}
+
+	if blockOffset < int64(len(fileHeader(s.version, s.keyHashSize))) || blockOffset >= stat.Size() {
+		return nil, fmt.Errorf("block offset %d is out of file of size %d", blockOffset, stat.Size())
+	}
+
+	if recordOffset < 0 {
+		return nil, fmt.Errorf("negative record offset %d", recordOffset)
+	}
+
+	return s.readRecordAt(Offsets{BlockOffset: blockOffset, RecordOffset: recordOffset})
+}
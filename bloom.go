@@ -0,0 +1,152 @@
+package zkv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+)
+
+const (
+	bloomFileExt = ".bloom"
+
+	// Number of filter bits per key, gives about 1% of false positives
+	bloomBitsPerKey = 10
+
+	// Number of bits set per key
+	bloomHashCount = 7
+
+	bloomMinKeys = 1024
+)
+
+// bloomFilter answers whether key may exist in store.
+// False positives fall through to the index lookup.
+type bloomFilter struct {
+	Bits     []uint64
+	Capacity int
+	Count    int
+
+	// Digest of index file saved along with filter
+	IndexDigest [sha256.Size]byte
+}
+
+func newBloomFilter(capacity int) *bloomFilter {
+	if capacity < bloomMinKeys {
+		capacity = bloomMinKeys
+	}
+
+	return &bloomFilter{
+		Bits:     make([]uint64, (capacity*bloomBitsPerKey+63)/64),
+		Capacity: capacity}
+}
+
+func (b *bloomFilter) add(keyHash [sha256.Size224]byte) {
+	for _, i := range b.bitIndexes(keyHash) {
+		b.Bits[i/64] |= 1 << (i % 64)
+	}
+
+	b.Count++
+}
+
+func (b *bloomFilter) mayContain(keyHash [sha256.Size224]byte) bool {
+	for _, i := range b.bitIndexes(keyHash) {
+		if b.Bits[i/64]&(1<<(i%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bitIndexes derives filter bit positions from already uniformly
+// distributed key hash by double hashing
+func (b *bloomFilter) bitIndexes(keyHash [sha256.Size224]byte) [bloomHashCount]uint64 {
+	h1 := binary.LittleEndian.Uint64(keyHash[0:8])
+	h2 := binary.LittleEndian.Uint64(keyHash[8:16])
+	m := uint64(len(b.Bits) * 64)
+
+	var indexes [bloomHashCount]uint64
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % m
+	}
+
+	return indexes
+}
+
+// initBloomFilter loads filter saved with loaded index or builds new one
+func (s *Store) initBloomFilter(indexLoaded bool) error {
+	if indexLoaded {
+		f, err := s.options.Storage.Open(s.filePath + bloomFileExt)
+		if err == nil {
+			defer f.Close()
+
+			var filter bloomFilter
+			err = gob.NewDecoder(f).Decode(&filter)
+			if err != nil && err != io.EOF {
+				return err
+			}
+
+			// Filter is valid only for index saved with it
+			if err == nil && filter.IndexDigest == s.indexDigest {
+				s.bloomFilter = &filter
+				return nil
+			}
+		}
+	}
+
+	s.rebuildBloomFilter()
+
+	return nil
+}
+
+// rebuildBloomFilter fills new filter with all keys of store
+func (s *Store) rebuildBloomFilter() {
+	keyCount := len(s.dataOffset) + len(s.bufferDataOffset)
+	s.bloomFilter = newBloomFilter(2 * keyCount)
+
+	var keyHash [sha256.Size224]byte
+	for keyHashStr := range s.dataOffset {
+		copy(keyHash[:], keyHashStr)
+		s.bloomFilter.add(keyHash)
+	}
+	for keyHashStr := range s.bufferDataOffset {
+		copy(keyHash[:], keyHashStr)
+		s.bloomFilter.add(keyHash)
+	}
+}
+
+func (s *Store) addToBloomFilter(keyHash [sha256.Size224]byte) {
+	keyHash = s.truncateKeyHash(keyHash)
+
+	if s.bloomFilter.Count >= s.bloomFilter.Capacity {
+		// Grow filter to keep false positive rate
+		s.rebuildBloomFilter()
+	}
+
+	s.bloomFilter.add(keyHash)
+}
+
+func (s *Store) saveBloomFilter() error {
+	s.bloomFilter.IndexDigest = s.indexDigest
+
+	buf := new(bytes.Buffer)
+
+	err := gob.NewEncoder(buf).Encode(s.bloomFilter)
+	if err != nil {
+		return err
+	}
+
+	f, err := s.options.Storage.Create(s.filePath + bloomFileExt)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
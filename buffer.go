@@ -0,0 +1,51 @@
+package zkv
+
+import "bytes"
+
+// removeBufferRecords removes records matching remove func and records
+// overwritten with DedupBufferWrites option from memory buffer and shifts
+// buffer offsets of the rest records
+func (s *Store) removeBufferRecords(remove func(record *Record) bool) error {
+	data := s.buffer.Bytes()
+	reader := bytes.NewReader(data)
+
+	// Records are moved in place, write position never passes read position
+	newOffsets := make(map[int64]int64)
+	var readOffset, writeOffset int64
+	for reader.Len() > 0 {
+		n, record, err := readRecord(reader)
+		if err != nil {
+			return err
+		}
+
+		// Overwritten records are not counted already
+		_, stale := s.staleBufferOffsets[readOffset]
+		switch {
+		case stale:
+		case remove(record):
+			s.recordCount--
+			s.bufferRecords--
+		default:
+			newOffsets[readOffset] = writeOffset
+			copy(data[writeOffset:], data[readOffset:readOffset+n])
+			writeOffset += n
+		}
+
+		readOffset += n
+	}
+
+	for key, offset := range s.bufferDataOffset {
+		newOffset, exists := newOffsets[offset]
+		if !exists {
+			delete(s.bufferDataOffset, key)
+			continue
+		}
+
+		s.bufferDataOffset[key] = newOffset
+	}
+
+	s.buffer.Truncate(int(writeOffset))
+	s.staleBufferOffsets = make(map[int64]struct{})
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package zkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// encodeKey encodes key for hashing. Gob encodes map entries in random
+// order, so keys containing maps of several entries are encoded in
+// canonical form with sorted map entries instead: zero marker byte and
+// value encoded by encodeCanonical. Other keys, including keys with nil,
+// empty and single-entry maps, are gob-encoded as before, so their hashes
+// do not change.
+func encodeKey(key interface{}) ([]byte, error) {
+	v := reflect.ValueOf(key)
+	if !containsUnorderedMap(v) {
+		return encode(key)
+	}
+
+	buf := bytes.NewBuffer([]byte{0})
+	buf.WriteString(v.Type().String())
+
+	err := encodeCanonical(buf, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// containsUnorderedMap reports whether value holds map of several entries
+// at any depth, which gob encodes in random order
+func containsUnorderedMap(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Len() > 1 {
+			return true
+		}
+
+		iter := v.MapRange()
+		for iter.Next() {
+			if containsUnorderedMap(iter.Key()) || containsUnorderedMap(iter.Value()) {
+				return true
+			}
+		}
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil() && containsUnorderedMap(v.Elem())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if containsUnorderedMap(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() && containsUnorderedMap(v.Field(i)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// encodeCanonical writes deterministic encoding of value: one byte
+// of kind tag followed by value bytes. Unexported struct fields are
+// skipped as gob does.
+func encodeCanonical(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Invalid:
+		buf.WriteByte('n')
+	case reflect.Bool:
+		buf.WriteByte('b')
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.Write(binary.AppendVarint([]byte{'i'}, v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.Write(binary.AppendUvarint([]byte{'u'}, v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		buf.Write(binary.BigEndian.AppendUint64([]byte{'f'}, math.Float64bits(v.Float())))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		buf.Write(binary.BigEndian.AppendUint64([]byte{'c'}, math.Float64bits(real(c))))
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(imag(c))))
+	case reflect.String:
+		buf.Write(binary.AppendUvarint([]byte{'s'}, uint64(v.Len())))
+		buf.WriteString(v.String())
+	case reflect.Slice, reflect.Array:
+		buf.Write(binary.AppendUvarint([]byte{'l'}, uint64(v.Len())))
+		for i := 0; i < v.Len(); i++ {
+			err := encodeCanonical(buf, v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		entries := make([][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entry := new(bytes.Buffer)
+
+			err := encodeCanonical(entry, iter.Key())
+			if err != nil {
+				return err
+			}
+
+			err = encodeCanonical(entry, iter.Value())
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, entry.Bytes())
+		}
+
+		// Keys are unique, so entries are ordered by keys
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i], entries[j]) < 0 })
+
+		buf.Write(binary.AppendUvarint([]byte{'m'}, uint64(len(entries))))
+		for _, entry := range entries {
+			buf.Write(entry)
+		}
+	case reflect.Struct:
+		buf.WriteByte('t')
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			buf.Write(binary.AppendUvarint(nil, uint64(len(field.Name))))
+			buf.WriteString(field.Name)
+
+			err := encodeCanonical(buf, v.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			buf.WriteByte('n')
+			return nil
+		}
+
+		return encodeCanonical(buf, v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			buf.WriteByte('n')
+			return nil
+		}
+
+		buf.WriteByte('e')
+		buf.WriteString(v.Elem().Type().String())
+
+		return encodeCanonical(buf, v.Elem())
+	default:
+		return fmt.Errorf("%w: %s", ErrUnhashableKey, v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,353 @@
+package zkv
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Values longer than MaxValueChunkSize are split into chunk records:
+//
+//	chunked value: 'c' + 8 bytes of value id + uvarint chunk count + uvarint value size
+//	value chunk:   'C' + 8 bytes of value id + chunk bytes
+//
+// Chunk records are set records with key hashes computed from key hash of
+// value and chunk number, so index rebuild and compaction keep them as any
+// other live record. Chunks are written before record of chunked value.
+// Value id is random, chunks of another value of the same key are detected
+// on read.
+const (
+	chunkedValueTag = 'c'
+	valueChunkTag   = 'C'
+)
+
+const valueIDSize = 8
+
+// Chunk must hold value id of StreamGob value
+const minValueChunkSize = 64
+
+type chunkedValue struct {
+	id    [valueIDSize]byte
+	count int
+	size  int64
+}
+
+func (v chunkedValue) marshal() []byte {
+	b := append([]byte{fastValueMarker, chunkedValueTag}, v.id[:]...)
+	b = binary.AppendUvarint(b, uint64(v.count))
+
+	return binary.AppendUvarint(b, uint64(v.size))
+}
+
+// parseChunkedValue returns description of chunked value
+func parseChunkedValue(valueBytes []byte) (v chunkedValue, ok bool) {
+	if len(valueBytes) < 2+valueIDSize || valueBytes[0] != fastValueMarker || valueBytes[1] != chunkedValueTag {
+		return v, false
+	}
+	copy(v.id[:], valueBytes[2:])
+
+	r := bytes.NewReader(valueBytes[2+valueIDSize:])
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return v, false
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return v, false
+	}
+	v.count, v.size = int(count), int64(size)
+
+	return v, true
+}
+
+// isValueChunk reports whether value bytes are chunk of chunked value
+func isValueChunk(valueBytes []byte) bool {
+	return len(valueBytes) >= 2+valueIDSize && valueBytes[0] == fastValueMarker && valueBytes[1] == valueChunkTag
+}
+
+// isInternalRecord reports whether value bytes are type definitions of
+// StreamGob values or value chunk, which are not user records
+func isInternalRecord(valueBytes []byte) bool {
+	return isGobTypeRecord(valueBytes) || isValueChunk(valueBytes)
+}
+
+// chunkKeyHash returns key hash of record of chunk of value of key
+func (s *Store) chunkKeyHash(keyHash [sha256.Size224]byte, i int) [sha256.Size224]byte {
+	b := append([]byte("zkv value chunk "), keyHash[:s.keyHashSize]...)
+	b = binary.AppendUvarint(b, uint64(i))
+
+	return s.truncateKeyHash(hashBytes(b))
+}
+
+// setChunked writes value read from r as chunks followed by record of
+// chunked value and deletes extra chunks of previous value.
+// Must be called under store write lock.
+func (s *Store) setChunked(keyHash [sha256.Size224]byte, r io.Reader, typeName string, expireAt int64) error {
+	var v chunkedValue
+	_, err := rand.Read(v.id[:])
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, s.options.MaxValueChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk := make([]byte, 0, 2+valueIDSize+n)
+			chunk = append(chunk, fastValueMarker, valueChunkTag)
+			chunk = append(chunk, v.id[:]...)
+			chunk = append(chunk, buf[:n]...)
+
+			err = s.setRecord(&Record{Type: RecordTypeSet, KeyHash: s.chunkKeyHash(keyHash, v.count), ValueBytes: chunk})
+			if err != nil {
+				return err
+			}
+			v.count++
+			v.size += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	err = s.deleteChunks(keyHash, v.count)
+	if err != nil {
+		return err
+	}
+
+	return s.setRecord(&Record{Type: RecordTypeSet, KeyHash: keyHash, ValueBytes: v.marshal(), ExpireAt: expireAt, TypeName: typeName, WrittenAt: s.writeTime()})
+}
+
+// deleteChunks deletes chunks of key starting from given chunk number.
+// Must be called under store write lock.
+func (s *Store) deleteChunks(keyHash [sha256.Size224]byte, from int) error {
+	for i := from; ; i++ {
+		chunkKeyHash := s.chunkKeyHash(keyHash, i)
+		if !s.exists(chunkKeyHash) {
+			return nil
+		}
+
+		err := s.deleteRecord(chunkKeyHash)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyChunks copies chunks of chunked value to another key and deletes
+// extra chunks of previous value of that key.
+// Must be called under store write lock.
+func (s *Store) copyChunks(fromKeyHash, toKeyHash [sha256.Size224]byte, v chunkedValue) error {
+	for i := 0; i < v.count; i++ {
+		record, err := s.getStoredRecord(s.chunkKeyHash(fromKeyHash, i))
+		if err != nil {
+			return fmt.Errorf("%w: chunk %d of value: %w", ErrCorruptBlock, i, err)
+		}
+
+		record.KeyHash = s.chunkKeyHash(toKeyHash, i)
+		err = s.setRecord(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.deleteChunks(toKeyHash, v.count)
+}
+
+// readChunk returns bytes of chunk of chunked value.
+// Must be called under store read lock.
+func (s *Store) readChunk(keyHash [sha256.Size224]byte, v chunkedValue, i int) ([]byte, error) {
+	record, err := s.getStoredRecord(s.chunkKeyHash(keyHash, i))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d of value: %w", ErrCorruptBlock, i, err)
+	}
+
+	if !isValueChunk(record.ValueBytes) || !bytes.Equal(record.ValueBytes[2:2+valueIDSize], v.id[:]) {
+		return nil, fmt.Errorf("%w: chunk %d belongs to another value", ErrCorruptBlock, i)
+	}
+
+	return record.ValueBytes[2+valueIDSize:], nil
+}
+
+// joinChunks returns complete bytes of chunked value.
+// Must be called under store read lock.
+func (s *Store) joinChunks(keyHash [sha256.Size224]byte, v chunkedValue) ([]byte, error) {
+	var b []byte
+	for i := 0; i < v.count; i++ {
+		chunk, err := s.readChunk(keyHash, v, i)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+
+	if int64(len(b)) != v.size {
+		return nil, fmt.Errorf("%w: chunked value of %d bytes has %d bytes", ErrCorruptBlock, v.size, len(b))
+	}
+
+	return b, nil
+}
+
+// SetStream writes bytes read from r as encoded value bytes of key like
+// Set of RawValue. With MaxValueChunkSize option value is written chunk
+// by chunk and is not held in memory completely unless ValueEncodeHook
+// is set. Store is locked until r is read.
+func (s *Store) SetStream(key interface{}, r io.Reader) error {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return err
+	}
+
+	if s.writeQueue != nil {
+		done := make(chan error, 1)
+		err = s.enqueueWrite(func() error {
+			done <- s.setStream(keyHash, r)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return <-done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.setStream(keyHash, r)
+}
+
+func (s *Store) setStream(keyHash [sha256.Size224]byte, r io.Reader) error {
+	if s.options.MaxValueChunkSize == 0 || s.options.ValueEncodeHook != nil {
+		valueBytes, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		return s.setValue(keyHash, valueBytes, "", 0)
+	}
+
+	s.counters.sets.Add(1)
+
+	return s.setChunked(keyHash, r, "", 0)
+}
+
+// GetStream returns reader of encoded value bytes of key as Get into
+// RawValue returns them. Chunked value is read chunk by chunk and is not
+// held in memory completely unless ValueDecodeHook is set. Reader fails
+// if value is changed while it is read.
+func (s *Store) GetStream(key interface{}) (io.ReadCloser, error) {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	s.counters.gets.Add(1)
+
+	record, err := s.getRecord(keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.expired(time.Now()) {
+		return nil, ErrNotExists
+	}
+
+	v, chunked := parseChunkedValue(record.ValueBytes)
+	if !chunked || s.options.ValueDecodeHook != nil {
+		b, err := s.recordValue(record)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	return &chunkReader{s: s, keyHash: keyHash, value: v}, nil
+}
+
+// chunkReader reads chunked value chunk by chunk
+type chunkReader struct {
+	s       *Store
+	keyHash [sha256.Size224]byte
+	value   chunkedValue
+
+	// Number of next chunk to read
+	next int
+
+	// Unread bytes of current chunk
+	chunk []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.next >= r.value.count {
+			return 0, io.EOF
+		}
+
+		err := r.readNext()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
+
+func (r *chunkReader) readNext() error {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if r.s.closed {
+		return ErrClosed
+	}
+
+	chunk, err := r.s.readChunk(r.keyHash, r.value, r.next)
+	if err != nil {
+		return err
+	}
+
+	// Type definitions of StreamGob value are prepended to its first chunk
+	if r.next == 0 {
+		chunk, err = r.s.expandGobValue(chunk)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.next++
+	r.chunk = chunk
+
+	return nil
+}
+
+func (r *chunkReader) Close() error {
+	r.next, r.chunk = r.value.count, nil
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package zkv
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+// Cluster rewrites values of given keys together into new block at the end
+// of store file, so reads of these keys touch a single block. Missing keys
+// are skipped. Old records stay in store file until compaction.
+func (s *Store) Cluster(keys []interface{}) error {
+	keyHashes := make([][sha256.Size224]byte, 0, len(keys))
+	for _, key := range keys {
+		keyHash, err := s.hashKey(key)
+		if err != nil {
+			return err
+		}
+
+		keyHashes = append(keyHashes, keyHash)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	for _, keyHash := range keyHashes {
+		if _, exists := s.bufferDataOffset[s.indexKey(keyHash)]; exists {
+			continue // duplicate key
+		}
+
+		record, err := s.getRecord(keyHash)
+		if errors.Is(err, ErrNotExists) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.bufferSetRecord(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.flush()
+}
@@ -0,0 +1,71 @@
+package zkv
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DecoderPool keeps zstd decoders for reuse by reads of one or more stores
+type DecoderPool struct {
+	pool    sync.Pool
+	options []zstd.DOption
+}
+
+// NewDecoderPool returns pool of decoders created with given options.
+// Decoders always decode synchronously without background goroutines.
+func NewDecoderPool(options ...zstd.DOption) *DecoderPool {
+	return &DecoderPool{options: append(options, zstd.WithDecoderConcurrency(1))}
+}
+
+// get returns decoder reading from r
+func (p *DecoderPool) get(r io.Reader) (*zstd.Decoder, error) {
+	if dec, ok := p.pool.Get().(*zstd.Decoder); ok {
+		err := dec.Reset(r)
+		if err != nil {
+			dec.Close()
+			return nil, err
+		}
+
+		return dec, nil
+	}
+
+	return zstd.NewReader(r, p.options...)
+}
+
+// put returns decoder to pool dropping its reader
+func (p *DecoderPool) put(dec *zstd.Decoder) {
+	if dec.Reset(nil) != nil {
+		return
+	}
+
+	p.pool.Put(dec)
+}
+
+// blockEncoder returns encoder of flushed blocks, own encoder is created
+// on first use
+func (s *Store) blockEncoder() (*zstd.Encoder, error) {
+	if s.options.Encoder != nil {
+		return s.options.Encoder, nil
+	}
+
+	if s.encoder == nil {
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(s.options.CompressionLevel), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("init encoder: %w", err)
+		}
+		s.encoder = encoder
+	}
+
+	return s.encoder, nil
+}
+
+// closeEncoder releases own encoder of store
+func (s *Store) closeEncoder() {
+	if s.encoder != nil {
+		s.encoder.Close()
+		s.encoder = nil
+	}
+}
@@ -0,0 +1,244 @@
+package zkv
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Compact rewrites store file keeping only the latest versions of live
+// records. New file is written to TempDir and then moved over store file.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.compact()
+}
+
+func (s *Store) compact() error {
+	s.compacting = true
+	defer func() { s.compacting = false }()
+
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	return s.rewrite(s.copyLiveRecords)
+}
+
+// CompactSorted works like Compact but writes live records ordered by key
+// hash, so blocks of new file cover sequential hash ranges. Records of
+// custom types and value chunks are written first in file order. All live
+// records with their values are held in memory while sorting, so
+// compaction needs memory of size of uncompressed live data.
+func (s *Store) CompactSorted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	s.compacting = true
+	defer func() { s.compacting = false }()
+
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	return s.rewrite(s.copySortedRecords)
+}
+
+// copySortedRecords copies live records ordered by key hash
+func (s *Store) copySortedRecords(newStore *Store) error {
+	var records []*Record
+	err := s.forEachKeptRecord(func(record *Record) error {
+		if record.Type != RecordTypeSet || isValueChunk(record.ValueBytes) {
+			return newStore.copyRecord(record)
+		}
+
+		records = append(records, record)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return bytes.Compare(records[i].KeyHash[:], records[j].KeyHash[:]) < 0
+	})
+
+	for _, record := range records {
+		err = newStore.copyRecord(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// autoCompact compacts store if ratio of all records count to live
+// records count exceeds CompactRatioThreshold
+func (s *Store) autoCompact() error {
+	if s.compacting || s.garbageRatio() <= s.options.CompactRatioThreshold {
+		return nil
+	}
+
+	return s.compact()
+}
+
+// rewrite replaces store file with new file filled by copy func
+// and adopts its index
+func (s *Store) rewrite(copy func(newStore *Store) error) error {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	tmpFilePath := s.tempFilePath()
+	defer s.options.Storage.Remove(tmpFilePath + indexFileExt)
+	defer s.options.Storage.Remove(tmpFilePath + indexLogFileExt)
+
+	// Temporary store needs no own bloom filter file, write-ahead log,
+	// published counters, manifest and schema version as metadata is copied.
+	// Records are copied without lock of temporary store, so it must not
+	// run expired keys reaper and async writes worker.
+	options := s.options
+	options.ExpireReaperInterval = 0
+	options.AsyncWrites = false
+	options.IntegrityManifest = false
+	options.BloomFilter = false
+	options.WALPath = ""
+	options.AutoCompact = false
+	options.ExpvarName = ""
+	options.SchemaVersion = 0
+
+	// Index of new store must use key hash size of store file
+	options.KeyHashBits = 8 * s.keyHashSize
+
+	newStore, err := OpenWithOptions(tmpFilePath, options)
+	if err != nil {
+		return err
+	}
+
+	err = copy(newStore)
+	if err == nil && s.metadata != nil {
+		err = newStore.writeMetadata(s.metadata)
+	}
+	if err != nil {
+		newStore.Close()
+		s.options.Storage.Remove(tmpFilePath)
+		return err
+	}
+
+	err = newStore.Close()
+	if err != nil {
+		s.options.Storage.Remove(tmpFilePath)
+		return err
+	}
+
+	// New store without records has no file
+	newFileExists, err := isFileExists(s.options.Storage, tmpFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Open handles prevent file replacement on some systems
+	s.closeIdleFiles()
+	s.closeWriteFile()
+	s.stopTails()
+
+	if newFileExists {
+		err = s.options.Storage.Rename(tmpFilePath, s.filePath)
+	} else {
+		err = s.options.Storage.Remove(s.filePath)
+	}
+	if err != nil {
+		s.options.Storage.Remove(tmpFilePath)
+		return err
+	}
+
+	// Offsets of new store are valid for moved file
+	s.dataOffset = newStore.dataOffset
+	s.touches = newStore.touches
+	s.blockStats = newStore.blockStats
+	s.version = newStore.version
+	s.recordCount = int64(len(s.dataOffset))
+
+	if s.options.useIndexFile {
+		return s.saveIndex()
+	}
+
+	return nil
+}
+
+// garbageRatio returns estimated ratio of all records count to live
+// records count
+func (s *Store) garbageRatio() float64 {
+	if s.recordCount == 0 {
+		return 1
+	}
+
+	liveCount := len(s.dataOffset) + len(s.bufferDataOffset)
+	if liveCount == 0 {
+		return float64(s.recordCount) + 1
+	}
+
+	return float64(s.recordCount) / float64(liveCount)
+}
+
+// GarbageBytes returns total size of flushed records which Compact would
+// drop: overwritten set records and delete records. Size is counted
+// before compression. Store file is fully scanned.
+func (s *Store) GarbageBytes() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var garbageBytes int64
+	err = s.scanRecords(func(offsets Offsets, record *Record) error {
+		switch record.Type {
+		case RecordTypeSet:
+			if latestOffsets, exists := s.dataOffset[s.indexKey(record.KeyHash)]; exists && latestOffsets == offsets {
+				return nil
+			}
+		case RecordTypeDelete, RecordTypeTouch:
+		default:
+			return nil // custom records are kept by compaction
+		}
+
+		b, err := record.Marshal()
+		if err != nil {
+			return err
+		}
+		garbageBytes += int64(len(b))
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return garbageBytes, nil
+}
@@ -0,0 +1,225 @@
+package zkv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errStopScan stops record scan without error
+var errStopScan = errors.New("stop scan")
+
+// CompactRange rewrites blocks of store file between given block offsets
+// as one block dropping overwritten records of the range. The rest of file
+// is copied as is. End offset is offset of block following the range or
+// size of store file. Delete records and touch records which may hide
+// records before the range are kept. Requires store file format version 2
+// or later.
+//
+// Only records of the range are decoded and held in memory, but the whole
+// store file is copied to new file under store write lock, so disk I/O
+// and pause of writes and reads are of size of store file as with Compact.
+func (s *Store) CompactRange(startOffset, endOffset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	s.compacting = true
+	defer func() { s.compacting = false }()
+
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	if s.version < formatVersionBlockHeader {
+		return fmt.Errorf("%w: partial compaction of store file version %d", ErrNotSupported, s.version)
+	}
+
+	stat, err := s.options.Storage.Stat(s.filePath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreStat, err)
+	}
+
+	if startOffset < int64(len(fileHeader(s.version, s.keyHashSize))) || endOffset > stat.Size() || startOffset >= endOffset {
+		return fmt.Errorf("wrong compaction range %d-%d of file of size %d", startOffset, endOffset, stat.Size())
+	}
+
+	type rangeRecord struct {
+		offsets Offsets
+		record  *Record
+	}
+
+	var records []rangeRecord
+	_, err = s.scanRecordsFrom(startOffset, func(offsets Offsets, record *Record) error {
+		if offsets.BlockOffset >= endOffset {
+			if offsets.BlockOffset != endOffset {
+				return fmt.Errorf("compaction range end %d is not block offset", endOffset)
+			}
+
+			return errStopScan
+		}
+
+		records = append(records, rangeRecord{offsets, record})
+
+		return nil
+	}, nil)
+	if err != nil && err != errStopScan {
+		return err
+	}
+
+	// Positions of the latest delete and touch records of keys in range
+	lastPositions := make(map[RecordType]map[string]int)
+	for i, r := range records {
+		if lastPositions[r.record.Type] == nil {
+			lastPositions[r.record.Type] = make(map[string]int)
+		}
+		lastPositions[r.record.Type][s.indexKey(r.record.KeyHash)] = i
+	}
+
+	var (
+		buf         bytes.Buffer
+		newOffsets  = make(map[string]Offsets)
+		keptRecords int
+	)
+	for i, r := range records {
+		key := s.indexKey(r.record.KeyHash)
+		latestOffsets, live := s.dataOffset[key]
+
+		switch r.record.Type {
+		case RecordTypeSet:
+			if !live || latestOffsets != r.offsets {
+				continue
+			}
+
+			// Touches are merged into kept record. Expired record is kept
+			// to hide previous records of key.
+			r.record.ExpireAt = s.touchedExpireAt(r.record.KeyHash, r.record.ExpireAt)
+			newOffsets[key] = Offsets{BlockOffset: startOffset, RecordOffset: int64(buf.Len())}
+		case RecordTypeDelete:
+			// Delete record hides set records before range
+			if live || lastPositions[RecordTypeDelete][key] != i {
+				continue
+			}
+		case RecordTypeTouch:
+			// Touch record changes expiration of set record before range
+			if !live || latestOffsets.BlockOffset >= startOffset || lastPositions[RecordTypeTouch][key] != i {
+				continue
+			}
+		}
+
+		b, err := r.record.Marshal()
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		keptRecords++
+	}
+
+	var block []byte
+	if buf.Len() > 0 {
+		header, data, err := s.encodeBlock(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		block = append(header.marshal(s.version), data...)
+	}
+
+	tmpFilePath := s.tempFilePath()
+	err = s.writeCompactedRange(tmpFilePath, startOffset, endOffset, block)
+	if err != nil {
+		s.options.Storage.Remove(tmpFilePath)
+		return err
+	}
+
+	// Open handles prevent file replacement on some systems
+	s.closeIdleFiles()
+	s.closeWriteFile()
+	s.stopTails()
+
+	err = s.options.Storage.Rename(tmpFilePath, s.filePath)
+	if err != nil {
+		s.options.Storage.Remove(tmpFilePath)
+		return err
+	}
+
+	// Blocks after range are moved by change of range size
+	shift := int64(len(block)) - (endOffset - startOffset)
+	for key, offsets := range s.dataOffset {
+		switch {
+		case offsets.BlockOffset >= endOffset:
+			offsets.BlockOffset += shift
+			s.dataOffset[key] = offsets
+		case offsets.BlockOffset >= startOffset:
+			s.dataOffset[key] = newOffsets[key]
+		}
+	}
+	s.recordCount -= int64(len(records) - keptRecords)
+
+	if s.options.useIndexFile {
+		return s.saveIndex()
+	}
+
+	return nil
+}
+
+// writeCompactedRange writes copy of store file with blocks of range
+// replaced by given block
+func (s *Store) writeCompactedRange(filePath string, startOffset, endOffset int64, block []byte) error {
+	src, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+	defer src.Close()
+
+	dst, err := s.options.Storage.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(dst, s.options.DiskBufferSize)
+
+	_, err = io.CopyN(w, src, startOffset)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	_, err = w.Write(block)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	_, err = src.Seek(endOffset, io.SeekStart)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	_, err = io.Copy(w, src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
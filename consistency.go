@@ -0,0 +1,32 @@
+package zkv
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// checkConsistency checks that every memory buffer offset points
+// to set record of the same key.
+// Must be called under store read lock.
+func (s *Store) checkConsistency() error {
+	for key, offset := range s.bufferDataOffset {
+		if offset < 0 || offset >= int64(s.buffer.Len()) {
+			return fmt.Errorf("buffer offset %d of key %x is out of buffer of length %d", offset, key, s.buffer.Len())
+		}
+
+		_, record, err := readRecord(bytes.NewReader(s.buffer.Bytes()[offset:]))
+		if err != nil {
+			return fmt.Errorf("read record at buffer offset %d of key %x: %w", offset, key, err)
+		}
+
+		if record.Type != RecordTypeSet {
+			return fmt.Errorf("record at buffer offset %d of key %x has type %d", offset, key, record.Type)
+		}
+
+		if !bytes.Equal(record.KeyHash[:], []byte(key)) {
+			return fmt.Errorf("record at buffer offset %d of key %x has key %x", offset, key, record.KeyHash)
+		}
+	}
+
+	return nil
+}
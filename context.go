@@ -0,0 +1,47 @@
+package zkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// operationContext returns context limited by OperationTimeout option
+func (s *Store) operationContext() (context.Context, context.CancelFunc) {
+	if s.options.OperationTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.options.OperationTimeout)
+	}
+
+	return context.Background(), func() {}
+}
+
+// runContext runs fn and waits for its completion or context cancellation
+func runContext(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return contextError(ctx.Err())
+	}
+}
+
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	return err
+}
@@ -2,6 +2,7 @@ package zkv
 
 import (
 	"runtime"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 )
@@ -11,7 +12,14 @@ var defaultOptions = Options{
 	CompressionLevel: zstd.SpeedDefault,
 	MemoryBufferSize: 4 * 1024 * 1024,
 	DiskBufferSize:   1 * 1024 * 1024,
+	Storage:          LocalStorage{},
 	useIndexFile:     true,
+
+	ErrorsBufferSize:      16,
+	CompactRatioThreshold: 2,
+	AdaptiveFlushInterval: time.Second,
 }
 
 const indexFileExt = ".idx"
+
+const indexLogFileExt = ".idxlog"
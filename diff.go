@@ -0,0 +1,121 @@
+package zkv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Diff compares live records of two store files by key hash and stored
+// value bytes. Returns hashes of keys existing only in store A, only in
+// store B and of keys with different values. Stores are opened read-only
+// without index file writes. Both stores are read sequentially, values
+// of the other store are read by key, so memory is bounded by indexes of
+// stores. Values written with different options (StreamGob, value hooks)
+// are reported as different.
+func Diff(pathA, pathB string) (onlyA, onlyB, differing [][sha256.Size224]byte, err error) {
+	a, err := openReadOnly(pathA, Options{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer a.Close()
+
+	b, err := openReadOnly(pathB, Options{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer b.Close()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+
+	err = forEachLiveRecord(a, now, func(record *Record) error {
+		recordB, err := liveRecord(b, record.KeyHash, now)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case recordB == nil:
+			onlyA = append(onlyA, record.KeyHash)
+		case !bytes.Equal(record.ValueBytes, recordB.ValueBytes):
+			differing = append(differing, record.KeyHash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = forEachLiveRecord(b, now, func(record *Record) error {
+		recordA, err := liveRecord(a, record.KeyHash, now)
+		if err == nil && recordA == nil {
+			onlyB = append(onlyB, record.KeyHash)
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sortKeyHashes(onlyA)
+	sortKeyHashes(onlyB)
+	sortKeyHashes(differing)
+
+	return onlyA, onlyB, differing, nil
+}
+
+// forEachLiveRecord calls fn for set records of store not expired at now.
+// Must be called under store read lock.
+func forEachLiveRecord(s *Store, now time.Time, fn func(record *Record) error) error {
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err != nil || !exists {
+		return err
+	}
+
+	return s.forEachKeptRecord(func(record *Record) error {
+		if record.Type != RecordTypeSet || record.expired(now) {
+			return nil
+		}
+
+		return fn(record)
+	})
+}
+
+// liveRecord returns the latest set record of key not expired at now,
+// nil if there is no such record.
+// Must be called under store read lock.
+func liveRecord(s *Store, keyHash [sha256.Size224]byte, now time.Time) (*Record, error) {
+	if !s.exists(keyHash) {
+		return nil, nil
+	}
+
+	record, err := s.getRecord(keyHash)
+	if err != nil {
+		if errors.Is(err, ErrNotExists) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if record.expired(now) {
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+func sortKeyHashes(keyHashes [][sha256.Size224]byte) {
+	sort.Slice(keyHashes, func(i, j int) bool {
+		return bytes.Compare(keyHashes[i][:], keyHashes[j][:]) < 0
+	})
+}
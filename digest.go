@@ -0,0 +1,48 @@
+package zkv
+
+import (
+	"crypto/sha256"
+)
+
+// Digest returns hash of live records of store which does not depend on
+// records order and store file layout: XOR of SHA-256 hashes of key hash
+// and value bytes of every live record. Stores with equal contents have
+// equal digests. Memory buffer is flushed before computation.
+func (s *Store) Digest() ([sha256.Size]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return [sha256.Size]byte{}, ErrClosed
+	}
+
+	err := s.flush()
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	var digest [sha256.Size]byte
+
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err != nil || !exists {
+		return digest, err
+	}
+
+	err = s.forEachKeptRecord(func(record *Record) error {
+		if record.Type != RecordTypeSet {
+			return nil
+		}
+
+		recordDigest := sha256.Sum256(append(record.KeyHash[:], record.ValueBytes...))
+		for i := range digest {
+			digest[i] ^= recordDigest[i]
+		}
+
+		return nil
+	})
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	return digest, nil
+}
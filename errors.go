@@ -2,4 +2,25 @@ package zkv
 
 import "errors"
 
-var ErrNotExists = errors.New("not exists")
+var (
+	ErrNotExists = errors.New("not exists")
+	ErrStoreOpen = errors.New("open store file")
+	ErrStoreStat = errors.New("stat store file")
+	ErrClosed    = errors.New("store is closed")
+	ErrTimeout   = errors.New("operation timed out")
+	ErrReadOnly  = errors.New("store is read-only")
+
+	ErrUnsupportedVersion = errors.New("unsupported store file format version")
+	ErrIndexVersion       = errors.New("unsupported index file format version")
+	ErrCorruptBlock       = errors.New("corrupt block")
+	ErrIndexMismatch      = errors.New("index does not match store file")
+	ErrCorruptIndex       = errors.New("corrupt index file")
+	ErrSchemaTooNew       = errors.New("store schema version is newer than supported")
+	ErrIntegrity          = errors.New("store file does not match manifest")
+	ErrShardsMismatch     = errors.New("shards count does not match store directory")
+
+	ErrNotSupported       = errors.New("operation not supported")
+	ErrReservedRecordType = errors.New("reserved record type")
+	ErrUnhashableKey      = errors.New("key type can not be hashed")
+	ErrValueNotPointer    = errors.New("value is not a non-nil pointer")
+)
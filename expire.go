@@ -0,0 +1,156 @@
+package zkv
+
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+)
+
+// SetExpireAt writes value which is treated as not existing since time t.
+// Expiration is checked on read by wall clock, so clock changes move it.
+// Expired records stay in store file until ExpireReaperInterval reaper
+// deletes them or compaction drops them.
+func (s *Store) SetExpireAt(key, value interface{}, t time.Time) error {
+	keyHash, valueBytes, err := s.encodeSet(key, value)
+	if err != nil {
+		return err
+	}
+
+	expireAt := t.UnixNano()
+	typeName := s.valueTypeName(value)
+
+	if s.writeQueue != nil {
+		return s.enqueueWrite(func() error { return s.setValue(keyHash, valueBytes, typeName, expireAt) })
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.setValue(keyHash, valueBytes, typeName, expireAt)
+}
+
+// expired reports whether set record is expired at moment now
+func (r *Record) expired(now time.Time) bool {
+	return r.ExpireAt != 0 && now.UnixNano() >= r.ExpireAt
+}
+
+func (s *Store) startReaper() {
+	s.reaperStop = make(chan struct{})
+	s.reaperDone = make(chan struct{})
+
+	go s.runReaper()
+}
+
+// runReaper periodically deletes expired keys. Ticker does not depend
+// on wall clock, so clock changes do not cause bursts of runs.
+func (s *Store) runReaper() {
+	defer close(s.reaperDone)
+
+	ticker := time.NewTicker(s.options.ExpireReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.reaperStop:
+			return
+		case <-ticker.C:
+			err := s.reapExpired()
+			if err != nil {
+				s.mu.Lock()
+				s.reportAsyncErr(err)
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// stopReaper waits for current run of reaper and stops it
+func (s *Store) stopReaper() {
+	s.reaperStopOnce.Do(func() { close(s.reaperStop) })
+
+	<-s.reaperDone
+}
+
+// reapExpired writes delete records for expired keys. Keys are collected
+// by full scan of store file under read lock.
+func (s *Store) reapExpired() error {
+	now := time.Now()
+
+	var expired [][sha256.Size224]byte
+
+	s.mu.RLock()
+	if s.closed || s.readOnly {
+		s.mu.RUnlock()
+		return nil
+	}
+
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err == nil && exists {
+		err = s.scanRecords(func(offsets Offsets, record *Record) error {
+			if latestOffsets, exists := s.dataOffset[s.indexKey(record.KeyHash)]; !exists || latestOffsets != offsets || record.Type != RecordTypeSet {
+				return nil
+			}
+
+			record.ExpireAt = s.touchedExpireAt(record.KeyHash, record.ExpireAt)
+			if record.expired(now) {
+				expired = append(expired, record.KeyHash)
+			}
+
+			return nil
+		})
+	}
+
+	var keyHash [sha256.Size224]byte
+	for key := range s.bufferDataOffset {
+		if err != nil {
+			break
+		}
+
+		copy(keyHash[:], key)
+
+		var record *Record
+		record, err = s.getRecord(keyHash)
+		if err == nil && record.expired(now) {
+			expired = append(expired, keyHash)
+		}
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.readOnly {
+		return nil
+	}
+
+	for _, keyHash := range expired {
+		// Key may be written again since scan
+		record, err := s.getRecord(keyHash)
+		if errors.Is(err, ErrNotExists) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if record.expired(now) {
+			err = s.deleteHashed(keyHash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
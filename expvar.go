@@ -0,0 +1,48 @@
+package zkv
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// counters of store operations published by ExpvarName option
+type counters struct {
+	sets    expvar.Int
+	gets    expvar.Int
+	deletes expvar.Int
+	flushes expvar.Int
+
+	// Reads served from memory buffer
+	bufferHits expvar.Int
+
+	// Size of blocks written to store file
+	bytesWritten expvar.Int
+
+	// Uncompressed size of records read from store file
+	bytesRead expvar.Int
+}
+
+// publishCounters publishes counters of store as expvar map of given name.
+// Map of store opened before with the same name gets counters of new
+// store.
+func (s *Store) publishCounters(name string) error {
+	var m *expvar.Map
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		m = expvar.NewMap(name)
+	case *expvar.Map:
+		m = v
+	default:
+		return fmt.Errorf("expvar %q is already published with type %T", name, v)
+	}
+
+	m.Set("sets", &s.counters.sets)
+	m.Set("gets", &s.counters.gets)
+	m.Set("deletes", &s.counters.deletes)
+	m.Set("flushes", &s.counters.flushes)
+	m.Set("bufferHits", &s.counters.bufferHits)
+	m.Set("bytesWritten", &s.counters.bytesWritten)
+	m.Set("bytesRead", &s.counters.bytesRead)
+
+	return nil
+}
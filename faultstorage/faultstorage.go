@@ -0,0 +1,205 @@
+// Package faultstorage provides zkv.Storage injecting write, sync and
+// truncate errors into files of wrapped storage. It is intended for tests
+// of failure handling.
+package faultstorage
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/nxshock/zkv"
+)
+
+// ErrInjected is default error of injected faults
+var ErrInjected = errors.New("injected fault")
+
+// Storage is zkv.Storage which injects faults into files of wrapped
+// storage. Faults are set per file name and apply to all handles
+// of file opened for writing.
+type Storage struct {
+	zkv.Storage
+
+	mu     sync.Mutex
+	faults map[string]*faults
+}
+
+type faults struct {
+	// Number of writes to file
+	writes int
+
+	// Number of write which fails and its error
+	failWrite    int
+	failWriteErr error
+
+	// Number of write which writes half of data
+	shortWrite int
+
+	syncErr     error
+	truncateErr error
+}
+
+// New returns Storage injecting faults into files of given storage
+func New(storage zkv.Storage) *Storage {
+	return &Storage{Storage: storage, faults: make(map[string]*faults)}
+}
+
+// FailWrite makes n-th write to file from now return err without writing
+// data. Nil err means ErrInjected.
+func (s *Storage) FailWrite(name string, n int, err error) {
+	if err == nil {
+		err = ErrInjected
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.fileFaults(name)
+	f.failWrite, f.failWriteErr = f.writes+n, err
+}
+
+// ShortWrite makes n-th write to file from now write half of data and
+// return io.ErrShortWrite
+func (s *Storage) ShortWrite(name string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.fileFaults(name)
+	f.shortWrite = f.writes + n
+}
+
+// FailSync makes syncs of file return err, nil err stops failing
+func (s *Storage) FailSync(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fileFaults(name).syncErr = err
+}
+
+// FailTruncate makes truncates of file return err, nil err stops failing
+func (s *Storage) FailTruncate(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fileFaults(name).truncateErr = err
+}
+
+// Writes returns number of writes to file
+func (s *Storage) Writes(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fileFaults(name).writes
+}
+
+// Reset removes all faults
+func (s *Storage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = make(map[string]*faults)
+}
+
+// fileFaults returns faults of file. Must be called under lock.
+func (s *Storage) fileFaults(name string) *faults {
+	f, exists := s.faults[name]
+	if !exists {
+		f = new(faults)
+		s.faults[name] = f
+	}
+
+	return f
+}
+
+func (s *Storage) Append(name string) (zkv.AppendFile, error) {
+	f, err := s.Storage.Append(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &appendFile{AppendFile: f, storage: s, name: name}, nil
+}
+
+func (s *Storage) Create(name string) (io.WriteCloser, error) {
+	f, err := s.Storage.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeFile{WriteCloser: f, storage: s, name: name}, nil
+}
+
+// write writes b to w applying write faults of file
+func (s *Storage) write(name string, w io.Writer, b []byte) (int, error) {
+	s.mu.Lock()
+	f := s.fileFaults(name)
+	f.writes++
+	failWrite, shortWrite := f.writes == f.failWrite, f.writes == f.shortWrite
+	failWriteErr := f.failWriteErr
+	s.mu.Unlock()
+
+	switch {
+	case failWrite:
+		return 0, failWriteErr
+	case shortWrite:
+		n, err := w.Write(b[:len(b)/2])
+		if err != nil {
+			return n, err
+		}
+
+		return n, io.ErrShortWrite
+	}
+
+	return w.Write(b)
+}
+
+type writeFile struct {
+	io.WriteCloser
+
+	storage *Storage
+	name    string
+}
+
+func (f *writeFile) Write(b []byte) (int, error) {
+	return f.storage.write(f.name, f.WriteCloser, b)
+}
+
+type appendFile struct {
+	zkv.AppendFile
+
+	storage *Storage
+	name    string
+}
+
+func (f *appendFile) Write(b []byte) (int, error) {
+	return f.storage.write(f.name, f.AppendFile, b)
+}
+
+func (f *appendFile) Truncate(size int64) error {
+	f.storage.mu.Lock()
+	err := f.storage.fileFaults(f.name).truncateErr
+	f.storage.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return f.AppendFile.Truncate(size)
+}
+
+// Sync syncs wrapped file if it supports sync
+func (f *appendFile) Sync() error {
+	f.storage.mu.Lock()
+	err := f.storage.fileFaults(f.name).syncErr
+	f.storage.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if syncer, ok := f.AppendFile.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+
+	return nil
+}
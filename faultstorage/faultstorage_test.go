@@ -0,0 +1,182 @@
+package faultstorage
+
+import (
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/nxshock/zkv"
+	"github.com/stretchr/testify/assert"
+)
+
+const filePath = "test.zkv"
+
+// openStore returns store with flushed records of keys 0-9 and
+// buffered records of keys 10-19
+func openStore(t *testing.T, storage *Storage, options zkv.Options) *zkv.Store {
+	options.Storage = storage
+
+	db, err := zkv.OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+
+		if i == 9 {
+			err = db.Flush()
+			assert.NoError(t, err)
+		}
+	}
+
+	return db
+}
+
+func fileSize(t *testing.T, storage *Storage) int64 {
+	stat, err := storage.Stat(filePath)
+	assert.NoError(t, err)
+
+	return stat.Size()
+}
+
+func assertValues(t *testing.T, storage *Storage) {
+	db, err := zkv.OpenWithOptions(filePath, zkv.Options{Storage: storage})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		var value int
+		err = db.Get(i, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+}
+
+func TestFailWrite(t *testing.T) {
+	storage := New(zkv.NewMemoryStorage())
+
+	db := openStore(t, storage, zkv.Options{})
+	size := fileSize(t, storage)
+
+	storage.FailWrite(filePath, 1, nil)
+
+	err := db.Flush()
+	assert.ErrorIs(t, err, ErrInjected)
+	assert.Equal(t, size, fileSize(t, storage))
+
+	// Buffered records are kept for next flush
+	err = db.Close()
+	assert.NoError(t, err)
+
+	assertValues(t, storage)
+}
+
+func TestShortWrite(t *testing.T) {
+	storage := New(zkv.NewMemoryStorage())
+
+	db := openStore(t, storage, zkv.Options{})
+	size := fileSize(t, storage)
+
+	storage.ShortWrite(filePath, 1)
+
+	err := db.Flush()
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+
+	// Partially written block is truncated
+	assert.Equal(t, size, fileSize(t, storage))
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	assertValues(t, storage)
+}
+
+func TestFailTruncate(t *testing.T) {
+	storage := New(zkv.NewMemoryStorage())
+
+	db := openStore(t, storage, zkv.Options{})
+
+	storage.ShortWrite(filePath, 1)
+	storage.FailTruncate(filePath, ErrInjected)
+
+	err := db.Flush()
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	assert.ErrorContains(t, err, "rollback failed")
+
+	storage.Reset()
+	db.Close()
+}
+
+func TestFlushRetries(t *testing.T) {
+	storage := New(zkv.NewMemoryStorage())
+
+	db := openStore(t, storage, zkv.Options{FlushRetries: 1})
+
+	storage.FailWrite(filePath, 1, syscall.EAGAIN)
+
+	err := db.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, storage.Writes(filePath))
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	assertValues(t, storage)
+}
+
+func TestFailSync(t *testing.T) {
+	const walPath = "test.wal"
+
+	storage := New(zkv.NewMemoryStorage())
+
+	db, err := zkv.OpenWithOptions(filePath, zkv.Options{Storage: storage, WALPath: walPath, WALSync: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	storage.FailSync(walPath, ErrInjected)
+
+	err = db.Set(2, 2)
+	assert.ErrorIs(t, err, ErrInjected)
+
+	storage.FailSync(walPath, nil)
+
+	err = db.Set(3, 3)
+	assert.NoError(t, err)
+}
+
+func TestFailSyncStoreFile(t *testing.T) {
+	const walPath = "test.wal"
+
+	storage := New(zkv.NewMemoryStorage())
+
+	db, err := zkv.OpenWithOptions(filePath, zkv.Options{Storage: storage, WALPath: walPath, WALSync: true})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	// Unsynced block is rolled back and log keeps its records
+	storage.FailSync(filePath, ErrInjected)
+
+	err = db.Flush()
+	assert.ErrorIs(t, err, ErrInjected)
+
+	info, err := storage.Stat(walPath)
+	assert.NoError(t, err)
+	assert.NotZero(t, info.Size())
+
+	storage.FailSync(filePath, nil)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	info, err = storage.Stat(walPath)
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,46 @@
+package zkv
+
+import (
+	"fmt"
+	"io"
+)
+
+// acquireFile returns store file read handle from pool or opens new one.
+// Blocks while MaxOpenFiles handles are in use.
+func (s *Store) acquireFile() (io.ReadSeekCloser, error) {
+	s.fileSlots <- struct{}{}
+
+	select {
+	case f := <-s.idleFiles:
+		return f, nil
+	default:
+	}
+
+	f, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		<-s.fileSlots
+		return nil, fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+
+	return f, nil
+}
+
+// releaseFile returns read handle acquired by acquireFile to pool
+func (s *Store) releaseFile(f io.ReadSeekCloser) {
+	s.idleFiles <- f
+	<-s.fileSlots
+}
+
+// closeIdleFiles closes pooled read handles. Must be called before store
+// file is replaced and after append to file of storage which handles do
+// not see changes.
+func (s *Store) closeIdleFiles() {
+	for {
+		select {
+		case f := <-s.idleFiles:
+			f.Close()
+		default:
+			return
+		}
+	}
+}
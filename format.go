@@ -0,0 +1,88 @@
+package zkv
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// Legacy files have no header and start directly with first zstd frame
+	formatVersionLegacy byte = 0
+
+	// Files starting with one byte header holding format version
+	formatVersionHeader byte = 1
+
+	// Files with header before every block holding its compressed
+	// and uncompressed sizes
+	formatVersionBlockHeader byte = 2
+
+	// Files with block header starting with block flags
+	formatVersionBlockFlags byte = 3
+
+	// Files with truncated key hashes, header holds key hash size
+	// in bytes after format version
+	formatVersionKeyHashSize byte = 4
+
+	// Version of newly created files
+	formatVersion = formatVersionBlockFlags
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// readFileHeader returns format version and key hash size of store file.
+// Missing or empty file gets current format version and zero key hash size.
+func readFileHeader(storage Storage, filePath string) (version byte, keyHashSize int, err error) {
+	f, err := storage.Open(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return formatVersion, 0, nil
+		}
+
+		return 0, 0, fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 1)
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		if err == io.EOF {
+			return formatVersion, 0, nil
+		}
+
+		return 0, 0, err
+	}
+
+	switch header[0] {
+	case zstdMagic[0]:
+		return formatVersionLegacy, sha256.Size224, nil
+	case formatVersionHeader, formatVersionBlockHeader, formatVersionBlockFlags:
+		return header[0], sha256.Size224, nil
+	case formatVersionKeyHashSize:
+		_, err = io.ReadFull(f, header)
+		if err != nil {
+			return 0, 0, err
+		}
+		if header[0] == 0 || header[0] > sha256.Size224 {
+			return 0, 0, fmt.Errorf("%w: key hash size %d", ErrUnsupportedVersion, header[0])
+		}
+
+		return formatVersionKeyHashSize, int(header[0]), nil
+	}
+
+	return 0, 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header[0])
+}
+
+// fileHeader returns header written at the beginning of new store file
+func fileHeader(version byte, keyHashSize int) []byte {
+	switch version {
+	case formatVersionLegacy:
+		return nil
+	case formatVersionKeyHashSize:
+		return []byte{version, byte(keyHashSize)}
+	}
+
+	return []byte{version}
+}
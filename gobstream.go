@@ -0,0 +1,293 @@
+package zkv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// StreamGob values are gob value messages without type definitions:
+//
+//	streamed value:   't' + 8 bytes of type id + gob value message
+//	type definitions: 'T' + gob type definition messages
+//
+// Type definitions are stored once per type as separate record with
+// key hash computed from type id. Type id is prefix of SHA-256 hash of
+// type definitions, so it is the same in every store.
+const (
+	gobStreamValueTag = 't'
+	gobTypeTag        = 'T'
+)
+
+const gobTypeIDSize = 8
+
+type gobTypeID [gobTypeIDSize]byte
+
+// gobTypes keeps long-lived gob encoders of value types and known
+// type definitions
+type gobTypes struct {
+	mu sync.Mutex
+
+	encoders    map[reflect.Type]*gobTypeEncoder
+	definitions map[gobTypeID][]byte
+}
+
+type gobTypeEncoder struct {
+	id      gobTypeID
+	buf     *bytes.Buffer
+	encoder *gob.Encoder
+}
+
+func newGobTypes() *gobTypes {
+	return &gobTypes{
+		encoders:    make(map[reflect.Type]*gobTypeEncoder),
+		definitions: make(map[gobTypeID][]byte)}
+}
+
+// encode encodes value as streamed value. Values which type definitions
+// can not be separated are gob-encoded as usual.
+func (t *gobTypes) encode(value interface{}) ([]byte, error) {
+	valueType := reflect.TypeOf(value)
+	if valueType == nil {
+		return encode(value)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, exists := t.encoders[valueType]
+	if !exists {
+		buf := new(bytes.Buffer)
+		encoder := gob.NewEncoder(buf)
+
+		// Second encoding of the same value has no type definitions
+		err := encoder.Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		first := append([]byte(nil), buf.Bytes()...)
+		buf.Reset()
+
+		err = encoder.Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(first, buf.Bytes()) || !isGobValueMessage(buf.Bytes()) {
+			return encode(value)
+		}
+
+		// Maps may be encoded in different order
+		definitions := first[:len(first)-buf.Len()]
+		if !isGobTypeMessages(definitions) {
+			return encode(value)
+		}
+		e = &gobTypeEncoder{buf: buf, encoder: encoder}
+		digest := sha256.Sum256(definitions)
+		copy(e.id[:], digest[:])
+
+		t.encoders[valueType] = e
+		t.definitions[e.id] = definitions
+	}
+
+	e.buf.Reset()
+	err := e.encoder.Encode(value)
+	if err != nil {
+		delete(t.encoders, valueType)
+		return nil, err
+	}
+
+	// Interface fields may bring definitions of new types
+	// which later values would depend on
+	if !isGobValueMessage(e.buf.Bytes()) {
+		delete(t.encoders, valueType)
+		return encode(value)
+	}
+
+	b := make([]byte, 0, 2+gobTypeIDSize+e.buf.Len())
+	b = append(b, fastValueMarker, gobStreamValueTag)
+	b = append(b, e.id[:]...)
+
+	return append(b, e.buf.Bytes()...), nil
+}
+
+// definition returns known type definitions of type id
+func (t *gobTypes) definition(id gobTypeID) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	definitions, exists := t.definitions[id]
+	return definitions, exists
+}
+
+// expand converts streamed value to complete gob stream by prepending
+// its known type definitions
+func (t *gobTypes) expand(valueBytes []byte) ([]byte, bool) {
+	id, ok := streamedGobTypeID(valueBytes)
+	if !ok {
+		return valueBytes, true
+	}
+
+	definitions, known := t.definition(id)
+	if !known {
+		return nil, false
+	}
+
+	b := make([]byte, 0, len(definitions)+len(valueBytes)-2-gobTypeIDSize)
+	b = append(b, definitions...)
+
+	return append(b, valueBytes[2+gobTypeIDSize:]...), true
+}
+
+func (t *gobTypes) addDefinition(id gobTypeID, definitions []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.definitions[id] = definitions
+}
+
+// isGobValueMessage reports whether b is single gob message
+// of value, not of type definition
+func isGobValueMessage(b []byte) bool {
+	definition, n, ok := readGobMessage(b)
+
+	return ok && !definition && n == len(b)
+}
+
+// isGobTypeMessages reports whether b is sequence of gob messages
+// of type definitions
+func isGobTypeMessages(b []byte) bool {
+	for len(b) > 0 {
+		definition, n, ok := readGobMessage(b)
+		if !ok || !definition {
+			return false
+		}
+
+		b = b[n:]
+	}
+
+	return true
+}
+
+// readGobMessage reads length of gob message and reports whether it
+// is type definition message
+func readGobMessage(b []byte) (definition bool, n int, ok bool) {
+	length, n, ok := readGobUint(b)
+	if !ok || uint64(len(b)-n) < length {
+		return false, 0, false
+	}
+
+	// Type definition messages have negative type id
+	typeID, _, ok := readGobUint(b[n : n+int(length)])
+	if !ok {
+		return false, 0, false
+	}
+
+	return typeID&1 == 1, n + int(length), true
+}
+
+// readGobUint reads unsigned integer of gob encoding: one byte for values
+// below 128, otherwise negated byte count followed by big-endian bytes
+func readGobUint(b []byte) (x uint64, n int, ok bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+
+	if b[0] < 0x80 {
+		return uint64(b[0]), 1, true
+	}
+
+	l := -int(int8(b[0]))
+	if l > 8 || len(b) < 1+l {
+		return 0, 0, false
+	}
+
+	for _, c := range b[1 : 1+l] {
+		x = x<<8 | uint64(c)
+	}
+
+	return x, 1 + l, true
+}
+
+// gobTypeKeyHash returns key hash of record of type definitions
+func gobTypeKeyHash(id gobTypeID) [sha256.Size224]byte {
+	return hashBytes(append([]byte("zkv gob type "), id[:]...))
+}
+
+// streamedGobTypeID returns type id of streamed value
+func streamedGobTypeID(valueBytes []byte) (id gobTypeID, ok bool) {
+	if len(valueBytes) < 2+gobTypeIDSize || valueBytes[0] != fastValueMarker || valueBytes[1] != gobStreamValueTag {
+		return id, false
+	}
+	copy(id[:], valueBytes[2:])
+
+	return id, true
+}
+
+// isGobTypeRecord reports whether value bytes are type definitions
+// of streamed values
+func isGobTypeRecord(valueBytes []byte) bool {
+	return len(valueBytes) >= 2 && valueBytes[0] == fastValueMarker && valueBytes[1] == gobTypeTag
+}
+
+// encodeStoreValue encodes value according to StreamGob option
+func (s *Store) encodeStoreValue(value interface{}) ([]byte, error) {
+	if b, ok := encodeFastValue(value); ok {
+		return b, nil
+	}
+
+	if s.options.StreamGob {
+		return s.gobTypes.encode(value)
+	}
+
+	return encode(value)
+}
+
+// storeGobType writes type definitions of streamed value if store
+// has no them yet.
+// Must be called under store write lock.
+func (s *Store) storeGobType(valueBytes []byte) error {
+	id, ok := streamedGobTypeID(valueBytes)
+	if !ok {
+		return nil
+	}
+
+	keyHash := gobTypeKeyHash(id)
+	if s.exists(keyHash) {
+		return nil
+	}
+
+	// Value copied from another store brings no known definitions
+	definitions, known := s.gobTypes.definition(id)
+	if !known {
+		return nil
+	}
+
+	return s.bufferSet(keyHash, append([]byte{fastValueMarker, gobTypeTag}, definitions...))
+}
+
+// expandGobValue converts streamed value to complete gob stream
+// by prepending its type definitions read from store if necessary.
+// Must be called under store read lock.
+func (s *Store) expandGobValue(valueBytes []byte) ([]byte, error) {
+	if b, ok := s.gobTypes.expand(valueBytes); ok {
+		return b, nil
+	}
+
+	id, _ := streamedGobTypeID(valueBytes)
+
+	record, err := s.getRecord(gobTypeKeyHash(id))
+	if err != nil {
+		return nil, fmt.Errorf("read type definitions %x of streamed value: %v", id, err)
+	}
+	if !isGobTypeRecord(record.ValueBytes) {
+		return nil, fmt.Errorf("record of type definitions %x has wrong format", id)
+	}
+	s.gobTypes.addDefinition(id, record.ValueBytes[2:])
+
+	b, _ := s.gobTypes.expand(valueBytes)
+
+	return b, nil
+}
@@ -0,0 +1,110 @@
+package zkv
+
+import (
+	"bytes"
+	"time"
+)
+
+// VersionInfo describes one write of key found by HistoryWithTime
+type VersionInfo struct {
+	// Encoded value bytes as Get into RawValue returns them. Nil for
+	// delete and for chunked value which chunks are overwritten.
+	Value []byte
+
+	Deleted bool
+
+	// Time of write, zero for records written without StoreWriteTimes
+	// option
+	WrittenAt time.Time
+}
+
+// HistoryWithTime returns all set and delete records of key kept in store
+// file and memory buffer from oldest to newest. Whole store file is
+// scanned. Compaction drops previous versions.
+func (s *Store) HistoryWithTime(key interface{}) ([]VersionInfo, error) {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	var versions []VersionInfo
+	visit := func(record *Record) error {
+		if s.indexKey(record.KeyHash) != s.indexKey(keyHash) {
+			return nil
+		}
+
+		version := VersionInfo{Deleted: record.Type == RecordTypeDelete}
+		if record.WrittenAt != 0 {
+			version.WrittenAt = time.Unix(0, record.WrittenAt)
+		}
+
+		switch record.Type {
+		case RecordTypeSet:
+			value, err := s.recordValue(record)
+			if err != nil {
+				// Chunks of previous values are overwritten by the latest one
+				if _, chunked := parseChunkedValue(record.ValueBytes); !chunked {
+					return err
+				}
+			}
+			version.Value = value
+		case RecordTypeDelete:
+		default:
+			return nil
+		}
+
+		versions = append(versions, version)
+
+		return nil
+	}
+
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		err = s.scanRecords(func(_ Offsets, record *Record) error {
+			return visit(record)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Records overwritten with DedupBufferWrites option are dropped
+	// from buffer on flush
+	reader := bytes.NewReader(s.buffer.Bytes())
+	for offset := int64(0); reader.Len() > 0; {
+		n, record, err := readRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, stale := s.staleBufferOffsets[offset]; !stale {
+			err = visit(record)
+			if err != nil {
+				return nil, err
+			}
+		}
+		offset += n
+	}
+
+	return versions, nil
+}
+
+// writeTime returns time of record write for StoreWriteTimes option
+func (s *Store) writeTime() int64 {
+	if !s.options.StoreWriteTimes {
+		return 0
+	}
+
+	return time.Now().UnixNano()
+}
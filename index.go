@@ -0,0 +1,373 @@
+package zkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Version of index file format
+const indexVersion byte = 1
+
+// IndexVersionPolicy defines behavior on index file of unsupported version
+type IndexVersionPolicy int
+
+const (
+	// Ignore index file and rebuild it from store file
+	IndexVersionRebuild IndexVersionPolicy = iota
+
+	// Return ErrIndexVersion from Open
+	IndexVersionError
+)
+
+// IndexWriteMode defines when index file is updated
+type IndexWriteMode int
+
+const (
+	// Rewrite index file on every flush
+	IndexWriteAlways IndexWriteMode = iota
+
+	// Write index file on Close only
+	IndexWriteOnClose
+
+	// Append offsets of every flushed block to index log file,
+	// rewrite index file on Close
+	IndexWriteIncremental
+)
+
+// indexHeader precedes offsets map in index file.
+// Legacy index files contain offsets map only.
+type indexHeader struct {
+	Version byte
+
+	// Number of keys in offsets map, zero in files of previous versions
+	Count int
+
+	// Size of store file covered by index, zero if unknown
+	FileSize int64
+
+	// Identifier of index log entries written on top of index
+	LogID int64
+
+	// Store metadata
+	Metadata map[string]string
+}
+
+// encodeIndex writes index header, offsets map and touches map.
+// Empty touches map is omitted.
+func encodeIndex(w io.Writer, dataOffset map[string]Offsets, touches map[string]int64, header indexHeader) error {
+	encoder := gob.NewEncoder(w)
+
+	header.Version = indexVersion
+	header.Count = len(dataOffset)
+
+	err := encoder.Encode(header)
+	if err != nil {
+		return err
+	}
+
+	err = encoder.Encode(dataOffset)
+	if err != nil || len(touches) == 0 {
+		return err
+	}
+
+	return encoder.Encode(touches)
+}
+
+func decodeIndex(b []byte) (map[string]Offsets, map[string]int64, indexHeader, error) {
+	var dataOffset map[string]Offsets
+	touches := make(map[string]int64)
+
+	decoder := gob.NewDecoder(bytes.NewReader(b))
+
+	var header indexHeader
+	err := decoder.Decode(&header)
+	if err != nil {
+		// Try legacy index file without header
+		legacyErr := gob.NewDecoder(bytes.NewReader(b)).Decode(&dataOffset)
+		if legacyErr != nil {
+			return nil, nil, indexHeader{}, err
+		}
+
+		return dataOffset, touches, indexHeader{}, nil
+	}
+
+	if header.Version != indexVersion {
+		return nil, nil, indexHeader{}, fmt.Errorf("%w: %d", ErrIndexVersion, header.Version)
+	}
+
+	dataOffset = make(map[string]Offsets, header.Count)
+	err = decoder.Decode(&dataOffset)
+	if err != nil {
+		return nil, nil, indexHeader{}, err
+	}
+
+	err = decoder.Decode(&touches)
+	if err != nil && err != io.EOF {
+		return nil, nil, indexHeader{}, err
+	}
+
+	return dataOffset, touches, header, nil
+}
+
+// indexLogEntry holds index changes of one flushed block
+type indexLogEntry struct {
+	// Identifier of index file entry was written on top of
+	LogID int64
+
+	Set     map[string]Offsets
+	Deleted []string
+	Touched map[string]int64
+
+	// Store metadata, nil if it is not set
+	Metadata map[string]string
+
+	// Size of store file after block write
+	FileSize int64
+}
+
+// updateIndex writes index changes of flushed block according to
+// IndexWriteMode
+func (s *Store) updateIndex(setOffsets map[string]Offsets, fileSize int64) error {
+	switch s.options.IndexWriteMode {
+	case IndexWriteOnClose:
+		s.indexDirty = true
+		return nil
+	case IndexWriteIncremental:
+		// Log needs index file to be written on top of
+		if s.indexLogID == 0 {
+			return s.saveIndex()
+		}
+
+		entry := indexLogEntry{LogID: s.indexLogID, Set: setOffsets, Touched: s.pendingTouches, Metadata: s.metadata, FileSize: fileSize}
+		for key := range s.deletedKeys {
+			if _, set := setOffsets[key]; !set {
+				entry.Deleted = append(entry.Deleted, key)
+			}
+		}
+		s.deletedKeys = make(map[string]struct{})
+		s.pendingTouches = make(map[string]int64)
+		s.indexDirty = true
+
+		return s.appendIndexLog(entry)
+	}
+
+	return s.saveIndex()
+}
+
+// appendIndexLog appends length-prefixed entry to index log file
+func (s *Store) appendIndexLog(entry indexLogEntry) error {
+	b, err := encode(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := s.options.Storage.Append(s.filePath + indexLogFileExt)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(binary.AppendUvarint(nil, uint64(len(b))), b...))
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// readIndexLog reads entries of index log file. Incomplete last entry
+// of interrupted write is ignored.
+func (s *Store) readIndexLog() ([]indexLogEntry, error) {
+	f, err := s.options.Storage.Open(s.filePath + indexLogFileExt)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []indexLogEntry
+	for len(b) > 0 {
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			break
+		}
+
+		var entry indexLogEntry
+		err = gob.NewDecoder(bytes.NewReader(b[n : n+int(l)])).Decode(&entry)
+		if err != nil {
+			break
+		}
+		entries = append(entries, entry)
+
+		b = b[n+int(l):]
+	}
+
+	return entries, nil
+}
+
+// removeIndexLog removes index log file if it exists
+func (s *Store) removeIndexLog() error {
+	err := s.options.Storage.Remove(s.filePath + indexLogFileExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// ExportIndex flushes memory buffer and writes index in index file format
+// to w. Exported index is valid for current store file only.
+func (s *Store) ExportIndex(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	return s.exportIndex(w)
+}
+
+// WriteIndexTo flushes memory buffer and writes index in index file format
+// to file of given path, for example to keep index copy or to move it to
+// another location. File can be loaded with IndexReader option or
+// ImportIndex.
+func (s *Store) WriteIndexTo(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	f, err := s.options.Storage.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(f, s.options.DiskBufferSize)
+
+	err = s.exportIndex(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		s.options.Storage.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
+
+// exportIndex flushes memory buffer and writes index to w.
+// Must be called under store write lock.
+func (s *Store) exportIndex(w io.Writer) error {
+	if !s.readOnly {
+		err := s.flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	fileSize, err := s.fileSize()
+	if err != nil {
+		return err
+	}
+
+	return encodeIndex(w, s.dataOffset, s.touches, indexHeader{FileSize: fileSize, Metadata: s.metadata})
+}
+
+// ImportIndex flushes memory buffer and replaces index with index read
+// from r as written by ExportIndex. Returns ErrIndexMismatch if index
+// was exported for store file of other size.
+func (s *Store) ImportIndex(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	err = s.importIndex(r)
+	if err != nil {
+		return err
+	}
+
+	if s.bloomFilter != nil {
+		s.rebuildBloomFilter()
+	}
+
+	if s.options.useIndexFile {
+		return s.saveIndex()
+	}
+
+	return nil
+}
+
+// importIndex replaces index with index read from r
+func (s *Store) importIndex(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	dataOffset, touches, header, err := decodeIndex(b)
+	if err != nil {
+		return err
+	}
+
+	fileSize, err := s.fileSize()
+	if err != nil {
+		return err
+	}
+
+	if header.FileSize != fileSize {
+		return fmt.Errorf("%w: index of file of size %d, file size is %d", ErrIndexMismatch, header.FileSize, fileSize)
+	}
+
+	s.dataOffset = dataOffset
+	s.touches = touches
+	s.metadata = header.Metadata
+	s.recordCount = int64(len(dataOffset))
+	s.indexDirty = true
+
+	return nil
+}
+
+// fileSize returns size of store file, zero if it does not exist
+func (s *Store) fileSize() (int64, error) {
+	stat, err := s.options.Storage.Stat(s.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("%w: %w", ErrStoreStat, err)
+	}
+
+	return stat.Size(), nil
+}
@@ -0,0 +1,103 @@
+package zkv
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jsonlRecord is a line of JSON lines export. Byte slices are
+// base64-encoded by encoding/json.
+type jsonlRecord struct {
+	KeyHash []byte `json:"keyHash"`
+
+	// Encoded value as it was passed to ValueEncodeHook: gob stream with
+	// type definitions or fast value. Gob values need their Go types to
+	// be decoded, so they are not converted to JSON.
+	Value []byte `json:"value"`
+
+	// Unix time in nanoseconds since which record is expired
+	ExpireAt int64 `json:"expireAt,omitempty"`
+
+	// Go type of value written with StoreTypeNames option
+	TypeName string `json:"typeName,omitempty"`
+}
+
+// ExportJSONL writes live records of store opened read-only with given
+// options as JSON lines with base64-encoded key hash, encoded value bytes
+// and expiration. Values are restored by hooks of options, chunked and
+// StreamGob values are written whole. Expired records are skipped.
+func ExportJSONL(storePath string, w io.Writer, options Options) error {
+	s, err := openReadOnly(storePath, options)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	encoder := json.NewEncoder(w)
+	err = forEachLiveRecord(s, time.Now(), func(record *Record) error {
+		if isInternalRecord(record.ValueBytes) {
+			return nil
+		}
+
+		value, err := s.recordValue(record)
+		if err != nil {
+			return err
+		}
+
+		return encoder.Encode(jsonlRecord{KeyHash: record.KeyHash[:], Value: value, ExpireAt: record.ExpireAt, TypeName: record.TypeName})
+	})
+	s.mu.RUnlock()
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	return s.Close()
+}
+
+// ImportJSONL writes records of JSON lines produced by ExportJSONL to store
+// with their expiration. Values are stored by hooks of options.
+func ImportJSONL(storePath string, r io.Reader, options Options) error {
+	s, err := OpenWithOptions(storePath, options)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var record jsonlRecord
+		err = decoder.Decode(&record)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			err = fmt.Errorf("line %d: %w", line, err)
+			break
+		}
+
+		if len(record.KeyHash) != sha256.Size224 {
+			err = fmt.Errorf("line %d: wrong key hash length %d", line, len(record.KeyHash))
+			break
+		}
+
+		var keyHash [sha256.Size224]byte
+		copy(keyHash[:], record.KeyHash)
+
+		err = s.setValue(keyHash, record.Value, record.TypeName, record.ExpireAt)
+		if err != nil {
+			break
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	return s.Close()
+}
@@ -0,0 +1,129 @@
+package zkv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const manifestFileExt = ".manifest"
+
+// manifest describes store file written on Close with IntegrityManifest
+// option
+type manifest struct {
+	FileSize int64
+
+	// SHA-256 hash of store file contents
+	Hash [sha256.Size]byte
+
+	// Number of live keys
+	KeyCount int
+}
+
+// fileManifest computes manifest of current store file.
+// Must be called under store lock.
+func (s *Store) fileManifest() (manifest, error) {
+	m := manifest{KeyCount: len(s.dataOffset)}
+
+	f, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return m, nil
+		}
+
+		return manifest{}, fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	m.FileSize, err = io.Copy(h, f)
+	if err != nil {
+		return manifest{}, err
+	}
+	copy(m.Hash[:], h.Sum(nil))
+
+	return m, nil
+}
+
+// writeManifest writes manifest of store file.
+// Must be called under store write lock.
+func (s *Store) writeManifest() error {
+	m, err := s.fileManifest()
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	err = gob.NewEncoder(buf).Encode(m)
+	if err != nil {
+		return err
+	}
+
+	f, err := s.options.Storage.Create(s.filePath + manifestFileExt)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// readManifest reads manifest written on Close, nil if there is none
+func (s *Store) readManifest() (*manifest, error) {
+	f, err := s.options.Storage.Open(s.filePath + manifestFileExt)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	m := new(manifest)
+	err = gob.NewDecoder(f).Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: manifest: %w", ErrIntegrity, err)
+	}
+
+	return m, nil
+}
+
+// verifyFile returns ErrIntegrity if store file does not match manifest.
+// It is checked before index load which may fail on damaged file.
+func (s *Store) verifyFile(saved *manifest) error {
+	current, err := s.fileManifest()
+	if err != nil {
+		return err
+	}
+
+	if current.FileSize != saved.FileSize {
+		return fmt.Errorf("%w: file size %d, expected %d", ErrIntegrity, current.FileSize, saved.FileSize)
+	}
+
+	if current.Hash != saved.Hash {
+		return fmt.Errorf("%w: file hash mismatch", ErrIntegrity)
+	}
+
+	return nil
+}
+
+// verifyKeyCount returns ErrIntegrity if loaded index does not match
+// manifest. Verified manifest is removed, so store file changed by
+// process which did not close store is not reported.
+func (s *Store) verifyKeyCount(saved *manifest) error {
+	if len(s.dataOffset) != saved.KeyCount {
+		return fmt.Errorf("%w: key count %d, expected %d", ErrIntegrity, len(s.dataOffset), saved.KeyCount)
+	}
+
+	return s.options.Storage.Remove(s.filePath + manifestFileExt)
+}
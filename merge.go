@@ -0,0 +1,71 @@
+package zkv
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// MergeFunc writes live values of source stores into destination store
+// opened with given options. Sources are opened read-only with source
+// options and read block by block in given order. If key already exists
+// in destination store, resolve is called with existing value bytes a and
+// new value bytes b and returned bytes are stored with expiration of b.
+// Records of custom types are not merged.
+func MergeFunc(destPath string, destOptions, srcOptions Options, resolve func(key [sha256.Size224]byte, a, b []byte) ([]byte, error), srcPaths ...string) error {
+	dest, err := OpenWithOptions(destPath, destOptions)
+	if err != nil {
+		return err
+	}
+
+	for _, srcPath := range srcPaths {
+		err = dest.mergeFrom(srcPath, srcOptions, resolve)
+		if err != nil {
+			dest.Close()
+			return fmt.Errorf("merge %s: %w", srcPath, err)
+		}
+	}
+
+	return dest.Close()
+}
+
+// mergeFrom writes live values of store at given path resolving
+// conflicts with existing keys
+func (s *Store) mergeFrom(srcPath string, srcOptions Options, resolve func(key [sha256.Size224]byte, a, b []byte) ([]byte, error)) error {
+	src, err := openReadOnly(srcPath, srcOptions)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return src.forEachKeptRecord(func(record *Record) error {
+		// Type definitions of StreamGob values and value chunks are not
+		// user records
+		if record.Type != RecordTypeSet || isInternalRecord(record.ValueBytes) {
+			return nil
+		}
+
+		valueBytes, err := src.recordValue(record)
+		if err != nil {
+			return err
+		}
+
+		existing, err := s.getGobBytes(record.KeyHash)
+		if err == nil {
+			valueBytes, err = resolve(record.KeyHash, existing, valueBytes)
+			if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, ErrNotExists) {
+			return err
+		}
+
+		return s.setValue(record.KeyHash, valueBytes, record.TypeName, record.ExpireAt)
+	})
+}
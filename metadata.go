@@ -0,0 +1,80 @@
+package zkv
+
+import "fmt"
+
+// SetMetadata replaces metadata of store, for example schema version or
+// creation time. Metadata is written as record of RecordTypeMetadata,
+// the latest such record is in effect. Compaction keeps the latest
+// metadata only.
+func (s *Store) SetMetadata(m map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.writeMetadata(m)
+}
+
+// GetMetadata returns copy of store metadata, nil if it was never set
+func (s *Store) GetMetadata() (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	return copyMetadata(s.metadata), nil
+}
+
+// writeMetadata writes metadata record to memory buffer
+func (s *Store) writeMetadata(m map[string]string) error {
+	m = copyMetadata(m)
+	if m == nil {
+		m = make(map[string]string)
+	}
+
+	valueBytes, err := encode(m)
+	if err != nil {
+		return err
+	}
+
+	err = s.writeRecord(&Record{Type: RecordTypeMetadata, ValueBytes: valueBytes})
+	if err != nil {
+		return err
+	}
+	s.metadata = m
+
+	return nil
+}
+
+// readMetadata makes metadata of record current
+func (s *Store) readMetadata(record *Record) error {
+	var m map[string]string
+	err := decode(record.ValueBytes, &m)
+	if err != nil {
+		return fmt.Errorf("%w: metadata: %w", ErrCorruptBlock, err)
+	}
+	s.metadata = m
+
+	return nil
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
@@ -1,35 +1,246 @@
 package zkv
 
-import "github.com/klauspost/compress/zstd"
+import (
+	"crypto/sha256"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
 
 type Options struct {
 	// Maximum number of concurrent reads
 	MaxParallelReads int
 
+	// Maximum number of open store file read handles,
+	// defaults to MaxParallelReads
+	MaxOpenFiles int
+
 	// Compression level
 	CompressionLevel zstd.EncoderLevel
 
-	// Memory write buffer size in bytes
+	// Encoder of flushed blocks which may be shared by several stores,
+	// CompressionLevel is ignored if set. Encoder is not closed by store.
+	Encoder *zstd.Encoder
+
+	// Pool of decoders reused by reads which may be shared by several stores
+	DecoderPool *DecoderPool
+
+	// Memory write buffer size in bytes, buffer is allocated on open
 	MemoryBufferSize int
 
+	// Flush memory buffer when it holds values of more keys than given
+	// number regardless of its size, zero means no limit
+	MaxBufferedKeys int
+
+	// Drop buffered record of key from memory buffer when key is set
+	// again before flush, so overwritten values are not written to disk.
+	// Overwritten records are dropped on flush. Key set again while its record is
+	// in buffer moves to position of its latest write in ForEachOrdered.
+	DedupBufferWrites bool
+
+	// Store blocks smaller than given size without compression
+	MinCompressBlockSize int
+
+	// Expected number of keys used to preallocate index
+	ExpectedKeys int
+
 	// Disk write buffer size in bytes
 	DiskBufferSize int
 
+	// Number of flush retries on transient file errors
+	FlushRetries int
+
+	// Delay before first flush retry, doubled on every next retry
+	FlushRetryBackoff time.Duration
+
+	// Directory for temporary files of long operations,
+	// defaults to store file directory
+	TempDir string
+
+	// Storage of store and index files, defaults to local file system
+	Storage Storage
+
+	// Called after every processed block of long operations
+	// like index rebuild and backup
+	ProgressFunc func(bytesProcessed, totalBytes int64)
+
+	// Check that written values can be decoded back
+	VerifyWrites bool
+
+	// Compact store on close when ratio of all records count to live
+	// records count exceeds CompactRatioThreshold, defaults to 2
+	AutoCompactOnClose    bool
+	CompactRatioThreshold float64
+
+	// Compact store after flush when ratio of all records count to live
+	// records count exceeds CompactRatioThreshold. Records written before
+	// open are not counted.
+	AutoCompact bool
+
+	// Skip records which backup store file already holds with the same
+	// value bytes and expiration. Costs read of every existing key of
+	// backup store file. Option of backup store options only.
+	BackupSkipUnchanged bool
+
+	// Publish counters of operations to expvar map of given name. Shards
+	// of sharded store get names with shard number suffix.
+	ExpvarName string
+
+	// Split values longer than given size into chunk records, read them
+	// chunk by chunk with GetStream. Chunks of overwritten and deleted
+	// values are deleted only while option is set. Minimum is 64 bytes.
+	MaxValueChunkSize int
+
+	// Keep store file open for appending between flushes instead of
+	// opening it on each flush. Size of store file is tracked in memory,
+	// so file must not be appended by others.
+	KeepWriteHandleOpen bool
+
+	// Write Go type name of value along with value to read it by TypeOf
+	StoreTypeNames bool
+
+	// Write time of writing along with set and delete records to read it
+	// by HistoryWithTime
+	StoreWriteTimes bool
+
+	// Schema version of values written by application, stored in metadata
+	// of store. Open returns ErrSchemaTooNew if store has newer version.
+	// Store of older version is opened, see SchemaVersion method.
+	SchemaVersion uint32
+
+	// Write manifest file with size and SHA-256 hash of store file on Close
+	// and verify store file on open, Open returns ErrIntegrity on mismatch.
+	// Hashing reads whole store file.
+	IntegrityManifest bool
+
+	// Keep bloom filter of existing keys to answer misses without index
+	// lookup. Filter is saved along with index file.
+	BloomFilter bool
+
+	// Keep store in memory only, store file path is used as a name
+	InMemory bool
+
+	// Skip damaged blocks on index rebuild instead of failing. Rebuild
+	// continues with next readable block, skipped ranges are returned
+	// by SkippedRanges.
+	BestEffortIndex bool
+
+	// Behavior on index file of unsupported version,
+	// defaults to index rebuild
+	IndexVersionPolicy IndexVersionPolicy
+
+	// Index written by ExportIndex to be used instead of index file on open
+	IndexReader io.Reader
+
+	// Moment of index file update, defaults to rewrite on every flush.
+	// Index file left behind store file is completed on open by scan of
+	// blocks written after it.
+	IndexWriteMode IndexWriteMode
+
+	// Encode values with long-lived gob encoder per type and store gob type
+	// definitions once as separate records instead of repeating them in
+	// every value. Reduces size of struct values.
+	StreamGob bool
+
+	// Transforms of encoded value bytes applied before value is written and
+	// after it is read, for example for encryption. Hooks run on single values
+	// before block compression, so well encrypted values are not compressed.
+	// Store must always be opened with the same hooks.
+	ValueEncodeHook func([]byte) ([]byte, error)
+	ValueDecodeHook func([]byte) ([]byte, error)
+
+	// Interval of background deletion of keys expired by SetExpireAt, zero
+	// disables it and expired keys are only hidden on read. Every run scans
+	// the whole store file and writes delete record for every expired key.
+	ExpireReaperInterval time.Duration
+
+	// Path of write-ahead log file. Every write is appended to log before
+	// it is buffered, log is truncated after flush and sync of store file.
+	// Records of log left by interrupted process are written to store on
+	// open.
+	WALPath string
+
+	// Sync write-ahead log file to disk after every write
+	WALSync bool
+
+	// Number of significant bits of key hashes: 128 or 224. Shorter
+	// hashes reduce index size at higher risk of key collision. Applied
+	// to new store files only, existing files keep their key hash size.
+	// 256 bits are not supported as key hashes of records and API are
+	// 28-byte SHA-224 hashes, Open returns ErrNotSupported for them.
+	KeyHashBits int
+
+	// Hash strings, byte slices and integers keys using fixed layout
+	// independent of gob. Store must always be opened with the same value.
+	PortableKeys bool
+
+	// Maximum duration of Get, Set and Delete operations, zero means no limit.
+	// Timed out Set and Delete may still be applied later.
+	OperationTimeout time.Duration
+
+	// Grow memory buffer flush threshold from MemoryBufferSize up to
+	// AdaptiveBufferMaxSize while buffer fills faster than
+	// AdaptiveFlushInterval and shrink it back on idle
+	AdaptiveBuffer        bool
+	AdaptiveBufferMaxSize int
+	AdaptiveFlushInterval time.Duration
+
+	// Drop buffered set records of never flushed key on delete instead of
+	// writing delete record. Costs memory buffer rewrite on such deletes.
+	SkipBufferedTombstones bool
+
+	// Handlers of custom type records written by WriteRecord,
+	// called for every such record on index rebuild
+	RecordHandlers map[RecordType]func(record *Record) error
+
+	// Queue Set and Delete operations to background writer and return
+	// without waiting for them. Queued operations are not visible to reads
+	// until applied. Sync waits for queued operations and flushes them,
+	// errors of queued operations are returned by Sync or Close.
+	AsyncWrites bool
+
+	// Capacity of channel returned by Errors
+	ErrorsBufferSize int
+
+	// Block background operations while channel returned by Errors is full
+	// instead of dropping errors. Channel must be read then.
+	BlockOnErrors bool
+
 	// Use index file
 	useIndexFile bool
+
+	// Open store read-only without writes of index file
+	readOnly bool
 }
 
 func (o *Options) setDefaults() {
-	o.useIndexFile = true // TODO: implement database search without index
+	o.useIndexFile = !o.InMemory // TODO: implement database search without index
 
 	if o.MaxParallelReads == 0 {
 		o.MaxParallelReads = defaultOptions.MaxParallelReads
 	}
 
+	if o.MaxOpenFiles == 0 {
+		o.MaxOpenFiles = o.MaxParallelReads
+	}
+
 	if o.CompressionLevel == 0 {
 		o.CompressionLevel = defaultOptions.CompressionLevel
 	}
 
+	if o.KeyHashBits == 0 {
+		o.KeyHashBits = 8 * sha256.Size224
+	}
+
+	if o.ErrorsBufferSize == 0 {
+		o.ErrorsBufferSize = defaultOptions.ErrorsBufferSize
+	}
+
+	if o.DecoderPool == nil {
+		o.DecoderPool = NewDecoderPool()
+	}
+
 	if o.MemoryBufferSize == 0 {
 		o.MemoryBufferSize = defaultOptions.MemoryBufferSize
 	}
@@ -37,4 +248,24 @@ func (o *Options) setDefaults() {
 	if o.DiskBufferSize == 0 {
 		o.DiskBufferSize = defaultOptions.DiskBufferSize
 	}
+
+	if o.AdaptiveBufferMaxSize == 0 {
+		o.AdaptiveBufferMaxSize = 16 * o.MemoryBufferSize
+	}
+
+	if o.AdaptiveFlushInterval == 0 {
+		o.AdaptiveFlushInterval = defaultOptions.AdaptiveFlushInterval
+	}
+
+	if o.CompactRatioThreshold == 0 {
+		o.CompactRatioThreshold = defaultOptions.CompactRatioThreshold
+	}
+
+	if o.Storage == nil {
+		if o.InMemory {
+			o.Storage = NewMemoryStorage()
+		} else {
+			o.Storage = defaultOptions.Storage
+		}
+	}
 }
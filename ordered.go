@@ -0,0 +1,99 @@
+package zkv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"time"
+)
+
+// ForEachOrdered calls fn for the latest value of every live key in order
+// of first write of the key: store file is scanned block by block in
+// append order followed by memory buffer. Key written again after its
+// deletion is ordered by its new first write. With DedupBufferWrites
+// option key set again before flush of its buffered record is ordered by
+// its latest write instead. Unlike map iteration order is deterministic.
+// Iteration stops on first error of fn. Store can not be modified from fn.
+func (s *Store) ForEachOrdered(fn func(keyHash [sha256.Size224]byte, value []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	var (
+		order     [][sha256.Size224]byte
+		positions = make(map[[sha256.Size224]byte]int)
+	)
+	visit := func(record *Record) {
+		switch record.Type {
+		case RecordTypeSet:
+			if _, seen := positions[record.KeyHash]; !seen {
+				positions[record.KeyHash] = len(order)
+				order = append(order, record.KeyHash)
+			}
+		case RecordTypeDelete:
+			delete(positions, record.KeyHash)
+		}
+	}
+
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		err = s.scanRecords(func(_ Offsets, record *Record) error {
+			visit(record)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Records overwritten with DedupBufferWrites option are dropped
+	// from buffer on flush
+	reader := bytes.NewReader(s.buffer.Bytes())
+	for offset := int64(0); reader.Len() > 0; {
+		n, record, err := readRecord(reader)
+		if err != nil {
+			return err
+		}
+
+		if _, stale := s.staleBufferOffsets[offset]; !stale {
+			visit(record)
+		}
+		offset += n
+	}
+
+	for i, keyHash := range order {
+		// Skip deleted keys and earlier lifetimes of written again keys
+		if position, exists := positions[keyHash]; !exists || position != i {
+			continue
+		}
+
+		record, err := s.getRecord(keyHash)
+		if err != nil {
+			return err
+		}
+
+		// Type definitions of StreamGob values and value chunks are not
+		// user records
+		if isInternalRecord(record.ValueBytes) || record.expired(time.Now()) {
+			continue
+		}
+
+		value, err := s.recordValue(record)
+		if err != nil {
+			return err
+		}
+
+		err = fn(keyHash, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
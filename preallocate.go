@@ -0,0 +1,41 @@
+package zkv
+
+import (
+	"fmt"
+	"os"
+)
+
+// Preallocate reserves disk space for store file to grow up to size bytes
+// without changing its size, so blocks flushed later are laid out
+// contiguously. Does nothing on platforms and file systems without
+// preallocation support and with storages other than LocalStorage.
+func (s *Store) Preallocate(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	f, err := s.options.Storage.Append(s.filePath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+
+	file, ok := f.(*os.File)
+	if !ok {
+		return f.Close()
+	}
+
+	err = preallocate(file, size)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("preallocate store file: %w", err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package zkv
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Mode of fallocate which reserves space beyond end of file
+const fallocKeepSize = 0x1
+
+func preallocate(f *os.File, size int64) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if size <= stat.Size() {
+		return nil
+	}
+
+	err = syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package zkv
+
+import "os"
+
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,64 @@
+package zkv
+
+import "crypto/sha256"
+
+// DeleteWhere deletes live keys for which pred returns true and returns
+// number of deleted keys. Value is passed to pred as returned by
+// ForEachOrdered. Store is locked for writes during scan, delete records
+// are flushed once at the end.
+func (s *Store) DeleteWhere(pred func(keyHash [sha256.Size224]byte, value []byte) (bool, error)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	err := s.flush()
+	if err != nil {
+		return 0, err
+	}
+
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var matched [][sha256.Size224]byte
+	err = s.forEachKeptRecord(func(record *Record) error {
+		// Type definitions of StreamGob values and value chunks are not
+		// user records
+		if record.Type != RecordTypeSet || isInternalRecord(record.ValueBytes) {
+			return nil
+		}
+
+		value, err := s.recordValue(record)
+		if err != nil {
+			return err
+		}
+
+		ok, err := pred(record.KeyHash, value)
+		if err != nil || !ok {
+			return err
+		}
+		matched = append(matched, record.KeyHash)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, keyHash := range matched {
+		err = s.deleteHashed(keyHash)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(matched), s.flush()
+}
@@ -0,0 +1,52 @@
+package zkv
+
+// SetReadOnly flushes memory buffer and switches store to read-only mode:
+// further writes, flushes and compactions return ErrReadOnly. Operations
+// queued in AsyncWrites mode are applied before switch. Store can not be
+// switched back to writable mode.
+func (s *Store) SetReadOnly() error {
+	// Queue lock goes first as writer may hold store lock to drain queue
+	s.writeQueueMu.Lock()
+	s.mu.Lock()
+	s.readOnly = true
+	s.mu.Unlock()
+	s.writeQueueMu.Unlock()
+
+	if s.writeQueue != nil {
+		s.stopWriter()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	err := s.flush()
+
+	// Report failed queued writes
+	if s.asyncErr != nil {
+		err = s.asyncErr
+		s.asyncErr = nil
+	}
+
+	return err
+}
+
+// openReadOnly opens store which does not write to its files. Options
+// of writes and background operations are ignored.
+func openReadOnly(filePath string, options Options) (*Store, error) {
+	options.readOnly = true
+	options.WALPath = ""
+	options.AsyncWrites = false
+	options.ExpireReaperInterval = 0
+	options.AutoCompact = false
+	options.AutoCompactOnClose = false
+	options.IntegrityManifest = false
+	options.BloomFilter = false
+	options.SchemaVersion = 0
+	options.ExpvarName = ""
+
+	return OpenWithOptions(filePath, options)
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"io"
+	"math"
 )
 
 type RecordType uint8
@@ -13,14 +15,36 @@ type RecordType uint8
 const (
 	RecordTypeSet RecordType = iota + 1
 	RecordTypeDelete
+
+	// Record changing expiration of the latest set record of key
+	RecordTypeTouch
+
+	// Record holding store metadata, the latest one is in effect
+	RecordTypeMetadata
 )
 
 type Record struct {
 	Type       RecordType
 	KeyHash    [28]byte
 	ValueBytes []byte
+
+	// Unix time in nanoseconds since which set record is expired,
+	// zero means no expiration
+	ExpireAt int64
+
+	// Go type of value written with StoreTypeNames option. Gob omits
+	// empty field, so records without type name are not changed.
+	TypeName string
+
+	// Unix time in nanoseconds of write of set or delete record written
+	// with StoreWriteTimes option
+	WrittenAt int64
 }
 
+// RawValue holds encoded value bytes. Get into *RawValue returns
+// stored bytes without decoding, Set of RawValue stores bytes as is.
+type RawValue []byte
+
 func newRecordBytes(recordType RecordType, keyHash [sha256.Size224]byte, valueBytes []byte) (*Record, error) {
 	record := &Record{
 		Type:       recordType,
@@ -36,7 +60,7 @@ func newRecord(recordType RecordType, key, value interface{}) (*Record, error) {
 		return nil, err
 	}
 
-	valueBytes, err := encode(value)
+	valueBytes, err := encodeValue(value)
 	if err != nil {
 		return nil, err
 	}
@@ -63,12 +87,21 @@ func (r *Record) Marshal() ([]byte, error) {
 }
 
 func readRecord(r io.Reader) (n int64, record *Record, err error) {
+	return readRecordLimited(r, math.MaxInt64)
+}
+
+// readRecordLimited reads record which must not be longer than limit bytes
+func readRecordLimited(r io.Reader, limit int64) (n int64, record *Record, err error) {
 	var recordBytesLen int64
 	err = binary.Read(r, binary.LittleEndian, &recordBytesLen)
 	if err != nil {
 		return 0, nil, err // TODO: вместо нуля должно быть реальное кол-во считанных байт
 	}
 
+	if recordBytesLen < 0 || recordBytesLen > limit-8 {
+		return 0, nil, fmt.Errorf("%w: wrong record length %d", ErrCorruptBlock, recordBytesLen)
+	}
+
 	recordBytes := make([]byte, int(recordBytesLen))
 
 	_, err = io.ReadAtLeast(r, recordBytes, int(recordBytesLen))
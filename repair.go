@@ -0,0 +1,78 @@
+package zkv
+
+// RepairReport describes damage found by Repair
+type RepairReport struct {
+	// Number of records written to repaired store file
+	RecoveredRecords int
+
+	// Errors of damaged blocks. Records of block following
+	// the error are lost.
+	BlockErrors []error
+}
+
+// Repair rewrites store file skipping damaged blocks and records and
+// rebuilds index from readable records. Deleted keys whose delete record
+// is lost may be restored with their previous values.
+func (s *Store) Repair() (RepairReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return RepairReport{}, ErrClosed
+	}
+
+	if s.readOnly {
+		return RepairReport{}, ErrReadOnly
+	}
+
+	s.compacting = true
+	defer func() { s.compacting = false }()
+
+	err := s.flush()
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	var report RepairReport
+
+	dataOffset := make(map[string]Offsets, len(s.dataOffset))
+	touches := make(map[string]int64)
+	err = s.scanRecordsTolerant(func(offsets Offsets, record *Record) error {
+		switch record.Type {
+		case RecordTypeSet:
+			dataOffset[s.indexKey(record.KeyHash)] = offsets
+			delete(touches, s.indexKey(record.KeyHash))
+		case RecordTypeDelete:
+			delete(dataOffset, s.indexKey(record.KeyHash))
+			delete(touches, s.indexKey(record.KeyHash))
+		case RecordTypeTouch:
+			if _, exists := dataOffset[s.indexKey(record.KeyHash)]; exists {
+				touches[s.indexKey(record.KeyHash)] = record.ExpireAt
+			}
+		}
+
+		return nil
+	}, func(blockOffset, blockSize int64, err error) error {
+		report.BlockErrors = append(report.BlockErrors, err)
+		return nil
+	})
+	if err != nil {
+		return RepairReport{}, err
+	}
+	s.dataOffset = dataOffset
+	s.touches = touches
+
+	err = s.rewrite(func(newStore *Store) error {
+		return s.scanRecordsTolerant(s.keptRecords(func(record *Record) error {
+			report.RecoveredRecords++
+			return newStore.copyRecord(record)
+		}), func(blockOffset, blockSize int64, err error) error {
+			return nil // already reported
+		})
+	})
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	return report, nil
+}
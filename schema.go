@@ -0,0 +1,87 @@
+package zkv
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Metadata key of schema version of SchemaVersion option
+const schemaVersionMetadataKey = "zkv.schemaVersion"
+
+// SchemaVersion returns schema version stored in metadata of store,
+// zero if it was never set. Version may be older than SchemaVersion
+// option of opened store.
+func (s *Store) SchemaVersion() (uint32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	return s.schemaVersion()
+}
+
+// SetSchemaVersion replaces schema version stored in metadata of store,
+// for example after migration of values written by older version.
+// Other metadata is kept.
+func (s *Store) SetSchemaVersion(version uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.writeSchemaVersion(version)
+}
+
+// checkSchemaVersion returns ErrSchemaTooNew if store has newer schema
+// version than SchemaVersion option. Version is stored to store without
+// schema version.
+func (s *Store) checkSchemaVersion() error {
+	version, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version > s.options.SchemaVersion {
+		return fmt.Errorf("%w: %d, supported %d", ErrSchemaTooNew, version, s.options.SchemaVersion)
+	}
+
+	if version == 0 {
+		return s.writeSchemaVersion(s.options.SchemaVersion)
+	}
+
+	return nil
+}
+
+// schemaVersion must be called under store read lock
+func (s *Store) schemaVersion() (uint32, error) {
+	v, exists := s.metadata[schemaVersionMetadataKey]
+	if !exists {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("schema version %q: %w", v, err)
+	}
+
+	return uint32(version), nil
+}
+
+// writeSchemaVersion must be called under store write lock
+func (s *Store) writeSchemaVersion(version uint32) error {
+	m := copyMetadata(s.metadata)
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[schemaVersionMetadataKey] = strconv.FormatUint(uint64(version), 10)
+
+	return s.writeMetadata(m)
+}
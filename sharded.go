@@ -0,0 +1,201 @@
+package zkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Name of file of shards count in store directory
+const shardsFileName = "shards"
+
+// ShardedStore spreads keys across several store files by key hash
+type ShardedStore struct {
+	shards []*Store
+}
+
+// OpenSharded opens or creates store of given number of shard files
+// in directory. Shards count is saved in directory on creation, opening
+// with another count returns ErrShardsMismatch.
+func OpenSharded(dir string, shards int, options Options) (*ShardedStore, error) {
+	if shards <= 0 {
+		return nil, fmt.Errorf("wrong shards count: %d", shards)
+	}
+
+	options.setDefaults()
+
+	err := makeShardDir(dir, options.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkShardsCount(dir, shards, options.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShardedStore{shards: make([]*Store, 0, shards)}
+	for i := 0; i < shards; i++ {
+		shardOptions := options
+		if options.ExpvarName != "" {
+			shardOptions.ExpvarName = fmt.Sprintf("%s.%d", options.ExpvarName, i)
+		}
+
+		store, err := OpenWithOptions(shardFilePath(dir, i), shardOptions)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("open shard %d: %w", i, err)
+		}
+
+		s.shards = append(s.shards, store)
+	}
+
+	return s, nil
+}
+
+func (s *ShardedStore) Set(key, value interface{}) error {
+	shard, err := s.shard(key)
+	if err != nil {
+		return err
+	}
+
+	return shard.Set(key, value)
+}
+
+func (s *ShardedStore) Get(key, value interface{}) error {
+	shard, err := s.shard(key)
+	if err != nil {
+		return err
+	}
+
+	return shard.Get(key, value)
+}
+
+func (s *ShardedStore) Delete(key interface{}) error {
+	shard, err := s.shard(key)
+	if err != nil {
+		return err
+	}
+
+	return shard.Delete(key)
+}
+
+// Flush flushes all shards
+func (s *ShardedStore) Flush() error {
+	var errs []error
+	for i, shard := range s.shards {
+		err := shard.Flush()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("flush shard %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Backup copies all shards to another directory
+func (s *ShardedStore) Backup(dir string) error {
+	err := makeShardDir(dir, defaultOptions.Storage)
+	if err != nil {
+		return err
+	}
+
+	err = writeShardsCount(dir, len(s.shards), defaultOptions.Storage)
+	if err != nil {
+		return err
+	}
+
+	for i, shard := range s.shards {
+		err := shard.Backup(shardFilePath(dir, i))
+		if err != nil {
+			return fmt.Errorf("backup shard %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes all shards
+func (s *ShardedStore) Close() error {
+	var errs []error
+	for i, shard := range s.shards {
+		err := shard.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("close shard %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// shard returns store of key
+func (s *ShardedStore) shard(key interface{}) (*Store, error) {
+	keyHash, err := s.shards[0].hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shards[binary.BigEndian.Uint64(keyHash[:8])%uint64(len(s.shards))], nil
+}
+
+func shardFilePath(dir string, shard int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.zkv", shard))
+}
+
+// makeShardDir creates directory of shard files on local file system
+func makeShardDir(dir string, storage Storage) error {
+	if _, ok := storage.(LocalStorage); !ok {
+		return nil
+	}
+
+	return os.MkdirAll(dir, 0755)
+}
+
+// checkShardsCount compares shards count with count saved in directory,
+// count of new directory is saved
+func checkShardsCount(dir string, shards int, storage Storage) error {
+	f, err := storage.Open(filepath.Join(dir, shardsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return writeShardsCount(dir, shards, storage)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	savedShards, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("read shards count: %w", err)
+	}
+
+	if savedShards != shards {
+		return fmt.Errorf("%w: directory has %d shards, opened with %d", ErrShardsMismatch, savedShards, shards)
+	}
+
+	return nil
+}
+
+func writeShardsCount(dir string, shards int, storage Storage) error {
+	f, err := storage.Create(filepath.Join(dir, shardsFileName))
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write([]byte(strconv.Itoa(shards) + "\n"))
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,83 @@
+package zkv
+
+// storeSnapshot is frozen index of flushed records of store file.
+// Blocks appended after snapshot do not move its records, store file
+// is not replaced while snapshot is in use.
+type storeSnapshot struct {
+	store *Store
+
+	dataOffset map[string]Offsets
+	touches    map[string]int64
+
+	// Size of store file when snapshot was taken
+	fileSize int64
+}
+
+// snapshot flushes memory buffer and returns snapshot of index. Store
+// file replacement waits until snapshot is released by release.
+func (s *Store) snapshot() (*storeSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	err := s.flush()
+	if err != nil {
+		return nil, err
+	}
+
+	fileSize, err := s.fileSize()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &storeSnapshot{
+		store:      s,
+		dataOffset: make(map[string]Offsets, len(s.dataOffset)),
+		touches:    make(map[string]int64, len(s.touches)),
+		fileSize:   fileSize}
+	for key, offsets := range s.dataOffset {
+		snapshot.dataOffset[key] = offsets
+	}
+	for key, expireAt := range s.touches {
+		snapshot.touches[key] = expireAt
+	}
+
+	s.snapshotMu.RLock()
+
+	return snapshot, nil
+}
+
+// release allows replacement of store file
+func (snapshot *storeSnapshot) release() {
+	snapshot.store.snapshotMu.RUnlock()
+}
+
+// forEachKeptRecord is Store.forEachKeptRecord over records of snapshot.
+// Store lock is not needed.
+func (snapshot *storeSnapshot) forEachKeptRecord(fn func(record *Record) error) error {
+	s := snapshot.store
+
+	headerSize := int64(len(fileHeader(s.version, s.keyHashSize)))
+	if snapshot.fileSize <= headerSize {
+		return nil
+	}
+
+	kept := s.keptRecordsOf(snapshot.dataOffset, snapshot.touches, fn)
+
+	_, err := s.scanRecordsFrom(headerSize, func(offsets Offsets, record *Record) error {
+		// Blocks appended after snapshot
+		if offsets.BlockOffset >= snapshot.fileSize {
+			return errStopScan
+		}
+
+		return kept(offsets, record)
+	}, nil)
+	if err == errStopScan {
+		return nil
+	}
+
+	return err
+}
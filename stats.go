@@ -0,0 +1,77 @@
+package zkv
+
+// Stats describes store state
+type Stats struct {
+	// Number of live keys
+	Keys int
+
+	// Number of records in store file and memory buffer including
+	// overwritten and delete records
+	Records int64
+
+	// Blocks scanned on index rebuild and written since open
+	Blocks BlockStats
+}
+
+// BlockStats describes sizes of store file blocks. Blocks of store opened
+// with index file are counted only since open.
+type BlockStats struct {
+	Count int
+
+	// Number of records per block
+	MinRecords int
+	MaxRecords int
+	AvgRecords float64
+
+	// Size of block in store file including block header
+	MinSize int64
+	MaxSize int64
+	AvgSize float64
+}
+
+// add accounts block of given number of records and size
+func (b *BlockStats) add(records int, size int64) {
+	if b.Count == 0 || records < b.MinRecords {
+		b.MinRecords = records
+	}
+	if records > b.MaxRecords {
+		b.MaxRecords = records
+	}
+	if b.Count == 0 || size < b.MinSize {
+		b.MinSize = size
+	}
+	if size > b.MaxSize {
+		b.MaxSize = size
+	}
+
+	b.AvgRecords = (b.AvgRecords*float64(b.Count) + float64(records)) / float64(b.Count+1)
+	b.AvgSize = (b.AvgSize*float64(b.Count) + float64(size)) / float64(b.Count+1)
+	b.Count++
+}
+
+// Stats returns current store statistics
+func (s *Store) Stats() (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return Stats{}, ErrClosed
+	}
+
+	return Stats{
+		Keys:    len(s.dataOffset) + s.bufferOnlyKeys(),
+		Records: s.recordCount,
+		Blocks:  s.blockStats}, nil
+}
+
+// bufferOnlyKeys returns number of buffered keys without flushed records
+func (s *Store) bufferOnlyKeys() int {
+	var count int
+	for key := range s.bufferDataOffset {
+		if _, flushed := s.dataOffset[key]; !flushed {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,335 @@
+package zkv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Storage provides access to store and index files
+type Storage interface {
+	// Open opens file for reading
+	Open(name string) (io.ReadSeekCloser, error)
+
+	// Append opens file for appending, creating it if not exists
+	Append(name string) (AppendFile, error)
+
+	// Create creates or truncates file for writing
+	Create(name string) (io.WriteCloser, error)
+
+	// Stat returns file info
+	Stat(name string) (fs.FileInfo, error)
+
+	// Remove removes file
+	Remove(name string) error
+
+	// Rename moves file replacing existing one
+	Rename(oldName, newName string) error
+}
+
+// AppendFile is file opened by Storage for appending
+type AppendFile interface {
+	io.WriteCloser
+
+	// Stat returns file info
+	Stat() (fs.FileInfo, error)
+
+	// Truncate changes file size
+	Truncate(size int64) error
+}
+
+// LocalStorage is Storage backed by local file system
+type LocalStorage struct{}
+
+func (LocalStorage) Open(name string) (io.ReadSeekCloser, error) {
+	return os.Open(name)
+}
+
+func (LocalStorage) Append(name string) (AppendFile, error) {
+	return os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+func (LocalStorage) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (LocalStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (LocalStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (LocalStorage) Rename(oldName, newName string) error {
+	err := os.Rename(oldName, newName)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// Files are on different devices
+	err = copyFile(oldName, newName)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(oldName)
+}
+
+// copyFile replaces destination file with copy of source file. Copy is
+// written to temporary file in destination directory and renamed over
+// destination, so failed copy keeps destination file intact.
+func copyFile(srcName, dstName string) error {
+	src, err := os.Open(srcName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(dstName), filepath.Base(dstName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Chmod(0644)
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(dst.Name(), dstName)
+}
+
+// MemoryStorage is Storage keeping files in memory
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string]*memoryFile)}
+}
+
+func (s *MemoryStorage) Open(name string) (io.ReadSeekCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, exists := s.files[name]
+	if !exists {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nopCloser{bytes.NewReader(file.data)}, nil
+}
+
+func (s *MemoryStorage) Append(name string) (AppendFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, exists := s.files[name]
+	if !exists {
+		file = &memoryFile{name: name, modTime: time.Now()}
+		s.files[name] = file
+	}
+
+	return &memoryAppendFile{storage: s, file: file}, nil
+}
+
+func (s *MemoryStorage) Create(name string) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := &memoryFile{name: name, modTime: time.Now()}
+	s.files[name] = file
+
+	return &memoryAppendFile{storage: s, file: file}, nil
+}
+
+func (s *MemoryStorage) Stat(name string) (fs.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, exists := s.files[name]
+	if !exists {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return file.stat(), nil
+}
+
+func (s *MemoryStorage) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.files[name]; !exists {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	delete(s.files, name)
+
+	return nil
+}
+
+func (s *MemoryStorage) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, exists := s.files[oldName]
+	if !exists {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+
+	delete(s.files, oldName)
+	file.name = newName
+	s.files[newName] = file
+
+	return nil
+}
+
+func (f *memoryFile) stat() fs.FileInfo {
+	return memoryFileInfo{name: filepath.Base(f.name), size: int64(len(f.data)), modTime: f.modTime}
+}
+
+type memoryAppendFile struct {
+	storage *MemoryStorage
+	file    *memoryFile
+}
+
+func (f *memoryAppendFile) Write(b []byte) (int, error) {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+
+	f.file.data = append(f.file.data, b...)
+	f.file.modTime = time.Now()
+
+	return len(b), nil
+}
+
+func (f *memoryAppendFile) Stat() (fs.FileInfo, error) {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+
+	return f.file.stat(), nil
+}
+
+func (f *memoryAppendFile) Truncate(size int64) error {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+
+	if size < int64(len(f.file.data)) {
+		// Copy data so readers opened before keep their view
+		f.file.data = append([]byte(nil), f.file.data[:size]...)
+	}
+
+	return nil
+}
+
+func (f *memoryAppendFile) Close() error {
+	return nil
+}
+
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi memoryFileInfo) Name() string       { return fi.name }
+func (fi memoryFileInfo) Size() int64        { return fi.size }
+func (fi memoryFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi memoryFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memoryFileInfo) IsDir() bool        { return false }
+func (fi memoryFileInfo) Sys() interface{}   { return nil }
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// writerStorage is write-only Storage streaming appended data of
+// a single store file to writer. Index files are discarded.
+type writerStorage struct {
+	w       io.Writer
+	size    int64
+	created bool
+}
+
+func (s *writerStorage) Open(name string) (io.ReadSeekCloser, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s *writerStorage) Append(name string) (AppendFile, error) {
+	s.created = true
+
+	return writerAppendFile{s}, nil
+}
+
+func (s *writerStorage) Create(name string) (io.WriteCloser, error) {
+	return writerAppendFile{&writerStorage{w: io.Discard}}, nil
+}
+
+func (s *writerStorage) Stat(name string) (fs.FileInfo, error) {
+	if !s.created {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return memoryFileInfo{name: name, size: s.size}, nil
+}
+
+func (s *writerStorage) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrNotSupported}
+}
+
+func (s *writerStorage) Rename(oldName, newName string) error {
+	return &fs.PathError{Op: "rename", Path: oldName, Err: ErrNotSupported}
+}
+
+type writerAppendFile struct {
+	storage *writerStorage
+}
+
+func (f writerAppendFile) Write(b []byte) (int, error) {
+	n, err := f.storage.w.Write(b)
+	f.storage.size += int64(n)
+
+	return n, err
+}
+
+func (f writerAppendFile) Stat() (fs.FileInfo, error) {
+	return memoryFileInfo{size: f.storage.size}, nil
+}
+
+func (f writerAppendFile) Truncate(size int64) error {
+	return ErrNotSupported
+}
+
+func (f writerAppendFile) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package zkv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SwapFile atomically replaces store file with file of another closed store
+// at newPath and reloads index. Index file of new store is moved too if
+// it exists. Unflushed changes of current store are discarded. New file
+// must have the same key hash size as store file.
+func (s *Store) SwapFile(newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	_, err := s.options.Storage.Stat(newPath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreStat, err)
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	_, keyHashSize, err := readFileHeader(s.options.Storage, newPath)
+	if err != nil {
+		return err
+	}
+
+	// Keys are hashed without store lock, so key hash size can not change
+	if keyHashSize != 0 && keyHashSize != s.keyHashSize {
+		return fmt.Errorf("%w: swap to file with key hash size %d, store uses %d", ErrNotSupported, keyHashSize, s.keyHashSize)
+	}
+
+	newIndexExists, err := isFileExists(s.options.Storage, newPath+indexFileExt)
+	if err != nil {
+		return err
+	}
+
+	// Open handles prevent file replacement on some systems
+	s.closeIdleFiles()
+	s.closeWriteFile()
+	s.stopTails()
+
+	// Old index and its log are removed first, so interrupted swap leaves
+	// store file without index instead of mismatched index
+	err = s.options.Storage.Remove(s.filePath + indexFileExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = s.removeIndexLog()
+	if err != nil {
+		return err
+	}
+
+	err = s.options.Storage.Rename(newPath, s.filePath)
+	if err != nil {
+		return err
+	}
+
+	// Store file is replaced, so index is reloaded even if index file
+	// is not moved
+	if newIndexExists {
+		err = s.options.Storage.Rename(newPath+indexFileExt, s.filePath+indexFileExt)
+	}
+
+	// Unflushed changes are discarded
+	if err == nil {
+		err = s.truncateWAL()
+	}
+
+	s.buffer.Reset()
+	s.bufferDataOffset = make(map[string]int64)
+	s.staleBufferOffsets = make(map[int64]struct{})
+	s.dataOffset = make(map[string]Offsets)
+	s.touches = make(map[string]int64)
+	s.recordCount = 0
+	s.bufferRecords = 0
+	s.blockStats = BlockStats{}
+	s.metadata = nil
+	s.bloomFilter = nil
+	s.indexDirty = false
+	s.indexLogID = 0
+	if s.deletedKeys != nil {
+		s.deletedKeys = make(map[string]struct{})
+		s.pendingTouches = make(map[string]int64)
+	}
+
+	loadErr := s.load()
+	if err != nil {
+		return err
+	}
+
+	return loadErr
+}
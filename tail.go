@@ -0,0 +1,289 @@
+package zkv
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeysSince returns hashes of keys set, deleted or touched by records of
+// blocks at or after given block offset in order of their first change.
+// Offset must be offset of block as returned by Blocks or size of store
+// file, offsets before first block mean whole file. Unflushed records are
+// not included.
+func (s *Store) KeysSince(blockOffset int64) ([][sha256.Size224]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	exists, err := isFileExists(s.options.Storage, s.filePath)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	if headerSize := int64(len(fileHeader(s.version, s.keyHashSize))); blockOffset < headerSize {
+		blockOffset = headerSize
+	}
+
+	var (
+		keyHashes [][sha256.Size224]byte
+		seen      = make(map[[sha256.Size224]byte]struct{})
+	)
+	_, err = s.scanRecordsFrom(blockOffset, func(_ Offsets, record *Record) error {
+		switch record.Type {
+		case RecordTypeSet:
+			// Type definitions of StreamGob values and value chunks are
+			// not user records
+			if isInternalRecord(record.ValueBytes) {
+				return nil
+			}
+		case RecordTypeDelete, RecordTypeTouch:
+		default:
+			return nil
+		}
+
+		if _, exists := seen[record.KeyHash]; !exists {
+			seen[record.KeyHash] = struct{}{}
+			keyHashes = append(keyHashes, record.KeyHash)
+		}
+
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyHashes, nil
+}
+
+// TailOffset returns size of store file, which is offset of the next
+// flushed block. Unflushed records are not included. Offset can be passed
+// to KeysSince or TailFrom later to continue from current state of file
+// if it was not replaced by compaction.
+func (s *Store) TailOffset() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	return s.fileSize()
+}
+
+// Number of flushed blocks queued for tail writer, tail which falls
+// further behind is stopped
+const tailQueueSize = 64
+
+// errTailQueueFull stops tail which writer does not keep up with flushes
+var errTailQueueFull = errors.New("writer is too slow, queue of blocks is full")
+
+// tailWriter receives store file bytes appended by flushes
+type tailWriter struct {
+	w io.Writer
+
+	// Size of part of store file written or queued to w
+	offset int64
+
+	// Flushed blocks written to w by writer goroutine, closed on stop
+	blocks chan []byte
+
+	// Closed when writer goroutine exits
+	done chan struct{}
+}
+
+// TailTo flushes memory buffer, writes store file to w and then writes
+// every flushed block to w as it is appended, so w receives growing copy
+// of store file. Blocks are written by separate goroutine, so slow w does
+// not block store, but tail which falls behind by more than 64 blocks is
+// stopped. Tail stops when stop is called, store is closed, write to w
+// fails or store file is replaced by compaction. Stop waits for queued
+// blocks to be written. Errors of stopped tails are sent to Errors
+// channel.
+func (s *Store) TailTo(w io.Writer) (stop func(), err error) {
+	return s.TailFrom(w, 0)
+}
+
+// TailFrom is TailTo which writes store file starting from given offset,
+// for example returned by TailOffset earlier, to resume previous tail.
+func (s *Store) TailFrom(w io.Writer, offset int64) (stop func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	if !s.readOnly {
+		err = s.flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fileSize, err := s.fileSize()
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > fileSize {
+		return nil, fmt.Errorf("wrong tail offset %d of file of size %d", offset, fileSize)
+	}
+
+	tail := &tailWriter{w: w, offset: offset}
+	err = s.copyToTail(tail)
+	if err != nil {
+		return nil, err
+	}
+
+	tail.blocks = make(chan []byte, tailQueueSize)
+	tail.done = make(chan struct{})
+	go s.runTail(tail)
+
+	if s.tails == nil {
+		s.tails = make(map[*tailWriter]struct{})
+	}
+	s.tails[tail] = struct{}{}
+
+	return func() {
+		s.mu.Lock()
+		if _, exists := s.tails[tail]; exists {
+			s.removeTail(tail)
+		}
+		s.mu.Unlock()
+
+		<-tail.done
+	}, nil
+}
+
+// copyToTail writes part of store file not written to tail yet
+func (s *Store) copyToTail(tail *tailWriter) error {
+	fileSize, err := s.fileSize()
+	if err != nil || fileSize <= tail.offset {
+		return err
+	}
+
+	f, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+	defer f.Close()
+
+	_, err = f.Seek(tail.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(tail.w, f, fileSize-tail.offset)
+	tail.offset += n
+
+	return err
+}
+
+// runTail writes queued blocks to tail writer until tail is stopped.
+// Failed tail is stopped, blocks queued before stop are dropped.
+func (s *Store) runTail(tail *tailWriter) {
+	defer close(tail.done)
+
+	var err error
+	for b := range tail.blocks {
+		if err != nil {
+			continue
+		}
+
+		_, err = tail.w.Write(b)
+		if err != nil {
+			s.mu.Lock()
+			if _, exists := s.tails[tail]; exists {
+				s.removeTail(tail)
+				s.sendError(fmt.Errorf("tail: %w", err))
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// writeTails queues flushed block to tails, tails with full queue are
+// stopped. Bytes of block are read once for all tails.
+// Must be called under store write lock.
+func (s *Store) writeTails() {
+	if len(s.tails) == 0 {
+		return
+	}
+
+	fileSize, err := s.fileSize()
+	if err == nil {
+		startOffset := fileSize
+		for tail := range s.tails {
+			if tail.offset < startOffset {
+				startOffset = tail.offset
+			}
+		}
+
+		var b []byte
+		b, err = s.readFileRange(startOffset, fileSize)
+		if err == nil {
+			for tail := range s.tails {
+				if tail.offset == fileSize {
+					continue
+				}
+
+				select {
+				case tail.blocks <- b[tail.offset-startOffset:]:
+					tail.offset = fileSize
+				default:
+					s.removeTail(tail)
+					s.sendError(fmt.Errorf("tail: %w", errTailQueueFull))
+				}
+			}
+
+			return
+		}
+	}
+
+	for tail := range s.tails {
+		s.removeTail(tail)
+		s.sendError(fmt.Errorf("tail: %w", err))
+	}
+}
+
+// readFileRange reads bytes of store file between given offsets
+func (s *Store) readFileRange(startOffset, endOffset int64) ([]byte, error) {
+	f, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+	defer f.Close()
+
+	_, err = f.Seek(startOffset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, endOffset-startOffset)
+	_, err = io.ReadFull(f, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// removeTail stops tail writer goroutine after queued blocks.
+// Must be called under store write lock.
+func (s *Store) removeTail(tail *tailWriter) {
+	delete(s.tails, tail)
+	close(tail.blocks)
+}
+
+// stopTails stops all tails before replacement of store file.
+// Must be called under store write lock.
+func (s *Store) stopTails() {
+	for tail := range s.tails {
+		s.removeTail(tail)
+		s.sendError(errors.New("tail: store file is replaced"))
+	}
+}
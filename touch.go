@@ -0,0 +1,72 @@
+package zkv
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+// Touch changes expiration of existing key to ttl since now without
+// rewriting its value. Zero ttl removes expiration. Touch record holds
+// key hash and expiration only.
+func (s *Store) Touch(key interface{}, ttl time.Duration) error {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return err
+	}
+
+	var expireAt int64
+	if ttl != 0 {
+		expireAt = time.Now().Add(ttl).UnixNano()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	record, err := s.getRecord(keyHash)
+	if err != nil {
+		return err
+	}
+
+	if record.expired(time.Now()) {
+		return ErrNotExists
+	}
+
+	return s.touch(&Record{Type: RecordTypeTouch, KeyHash: keyHash, ExpireAt: expireAt})
+}
+
+// touch writes touch record of existing key
+func (s *Store) touch(record *Record) error {
+	key := s.indexKey(record.KeyHash)
+
+	s.touches[key] = record.ExpireAt
+	if s.pendingTouches != nil {
+		s.pendingTouches[key] = record.ExpireAt
+	}
+
+	return s.writeRecord(record)
+}
+
+// untouch drops touches of key overwritten or deleted
+func (s *Store) untouch(key string) {
+	delete(s.touches, key)
+	if s.pendingTouches != nil {
+		delete(s.pendingTouches, key)
+	}
+}
+
+// touchedExpireAt returns expiration of key hash merged with its touches
+func (s *Store) touchedExpireAt(keyHash [sha256.Size224]byte, expireAt int64) int64 {
+	if touchExpireAt, touched := s.touches[s.indexKey(keyHash)]; touched {
+		return touchExpireAt
+	}
+
+	return expireAt
+}
@@ -3,10 +3,15 @@ package zkv
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"reflect"
+	"syscall"
 )
 
 func encode(value interface{}) ([]byte, error) {
@@ -16,11 +21,173 @@ func encode(value interface{}) ([]byte, error) {
 }
 
 func decode(b []byte, value interface{}) error {
+	if raw, ok := value.(*RawValue); ok {
+		*raw = append(RawValue(nil), b...)
+		return nil
+	}
+
+	if len(b) > 0 && b[0] == fastValueMarker {
+		return decodeFastValue(b[1:], value)
+	}
+
 	return gob.NewDecoder(bytes.NewReader(b)).Decode(value)
 }
 
+// Gob stream starts with non-zero message length,
+// so zero first byte marks fast encoded value
+const fastValueMarker = 0
+
+// encodeValue encodes integers and byte arrays as fast value:
+// zero marker byte, type tag and value bytes. Other values are gob-encoded.
+//
+//	signed integer:   'i' + varint
+//	unsigned integer: 'u' + uvarint
+//	byte array:       'a' + bytes
+func encodeValue(value interface{}) ([]byte, error) {
+	if b, ok := encodeFastValue(value); ok {
+		return b, nil
+	}
+
+	return encode(value)
+}
+
+// encodeFastValue encodes value as fast value if it has supported type
+func encodeFastValue(value interface{}) ([]byte, bool) {
+	switch value := value.(type) {
+	case int:
+		return binary.AppendVarint([]byte{fastValueMarker, 'i'}, int64(value)), true
+	case int8:
+		return binary.AppendVarint([]byte{fastValueMarker, 'i'}, int64(value)), true
+	case int16:
+		return binary.AppendVarint([]byte{fastValueMarker, 'i'}, int64(value)), true
+	case int32:
+		return binary.AppendVarint([]byte{fastValueMarker, 'i'}, int64(value)), true
+	case int64:
+		return binary.AppendVarint([]byte{fastValueMarker, 'i'}, value), true
+	case uint:
+		return binary.AppendUvarint([]byte{fastValueMarker, 'u'}, uint64(value)), true
+	case uint8:
+		return binary.AppendUvarint([]byte{fastValueMarker, 'u'}, uint64(value)), true
+	case uint16:
+		return binary.AppendUvarint([]byte{fastValueMarker, 'u'}, uint64(value)), true
+	case uint32:
+		return binary.AppendUvarint([]byte{fastValueMarker, 'u'}, uint64(value)), true
+	case uint64:
+		return binary.AppendUvarint([]byte{fastValueMarker, 'u'}, value), true
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, 2+v.Len())
+		b[0], b[1] = fastValueMarker, 'a'
+		reflect.Copy(reflect.ValueOf(b[2:]), v)
+
+		return b, true
+	}
+
+	return nil, false
+}
+
+// decodeFastValue decodes fast encoded value without marker byte
+// into pointer to integer, byte array or byte slice
+func decodeFastValue(b []byte, value interface{}) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Pointer || v.IsNil() || len(b) == 0 {
+		return fmt.Errorf("can not decode fast value into %T", value)
+	}
+	v = v.Elem()
+
+	switch b[0] {
+	case 'i':
+		x, n := binary.Varint(b[1:])
+		if n <= 0 {
+			return fmt.Errorf("wrong varint value")
+		}
+
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if !v.OverflowInt(x) {
+				v.SetInt(x)
+				return nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if x >= 0 && !v.OverflowUint(uint64(x)) {
+				v.SetUint(uint64(x))
+				return nil
+			}
+		}
+	case 'u':
+		x, n := binary.Uvarint(b[1:])
+		if n <= 0 {
+			return fmt.Errorf("wrong uvarint value")
+		}
+
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if x <= math.MaxInt64 && !v.OverflowInt(int64(x)) {
+				v.SetInt(int64(x))
+				return nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if !v.OverflowUint(x) {
+				v.SetUint(x)
+				return nil
+			}
+		}
+	case 'a':
+		switch {
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 && v.Len() == len(b)-1:
+			reflect.Copy(v, reflect.ValueOf(b[1:]))
+			return nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), b[1:]...))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can not decode fast value of type %q into %T", b[0], value)
+}
+
+// Gob type id of []byte sent as signed integer
+const gobBytesTypeID = 5 << 1
+
+// decodeBytesValue returns bytes of value encoded from byte slice by gob
+// or from byte array as fast value without copying them
+func decodeBytesValue(b []byte) ([]byte, bool) {
+	if len(b) >= 2 && b[0] == fastValueMarker && b[1] == 'a' {
+		return b[2:], true
+	}
+
+	// Gob stream of single []byte value: message length, type id,
+	// zero singleton field delta, length of bytes and bytes
+	msgLen, n, ok := readGobUint(b)
+	if !ok || msgLen != uint64(len(b)-n) {
+		return nil, false
+	}
+	b = b[n:]
+
+	typeID, n, ok := readGobUint(b)
+	if !ok || typeID != gobBytesTypeID || len(b) == n || b[n] != 0 {
+		return nil, false
+	}
+	b = b[n+1:]
+
+	length, n, ok := readGobUint(b)
+	if !ok || length != uint64(len(b)-n) {
+		return nil, false
+	}
+	b = b[n:]
+
+	return b, true
+}
+
+// checkDecodable decodes value bytes into new value of the same type as value
+func checkDecodable(b []byte, value interface{}) error {
+	return decode(b, reflect.New(reflect.TypeOf(value)).Interface())
+}
+
 func hashInterface(value interface{}) ([sha256.Size224]byte, error) {
-	valueBytes, err := encode(value)
+	valueBytes, err := encodeKey(value)
 	if err != nil {
 		return [sha256.Size224]byte{}, err
 	}
@@ -28,6 +195,55 @@ func hashInterface(value interface{}) ([sha256.Size224]byte, error) {
 	return hashBytes(valueBytes), nil
 }
 
+// hashPortableKey hashes strings, byte slices and integers using
+// fixed layout independent of gob: one byte of type tag followed by
+// key bytes. Other types are gob-encoded.
+//
+//	string:           's' + UTF-8 bytes
+//	[]byte:           'b' + bytes
+//	signed integer:   'i' + 8 bytes of big-endian int64
+//	unsigned integer: 'u' + 8 bytes of big-endian uint64
+//	other types:      'g' + bytes encoded by encodeKey
+func hashPortableKey(key interface{}) ([sha256.Size224]byte, error) {
+	var b []byte
+
+	switch key := key.(type) {
+	case string:
+		b = append([]byte{'s'}, key...)
+	case []byte:
+		b = append([]byte{'b'}, key...)
+	case int:
+		b = binary.BigEndian.AppendUint64([]byte{'i'}, uint64(key))
+	case int8:
+		b = binary.BigEndian.AppendUint64([]byte{'i'}, uint64(key))
+	case int16:
+		b = binary.BigEndian.AppendUint64([]byte{'i'}, uint64(key))
+	case int32:
+		b = binary.BigEndian.AppendUint64([]byte{'i'}, uint64(key))
+	case int64:
+		b = binary.BigEndian.AppendUint64([]byte{'i'}, uint64(key))
+	case uint:
+		b = binary.BigEndian.AppendUint64([]byte{'u'}, uint64(key))
+	case uint8:
+		b = binary.BigEndian.AppendUint64([]byte{'u'}, uint64(key))
+	case uint16:
+		b = binary.BigEndian.AppendUint64([]byte{'u'}, uint64(key))
+	case uint32:
+		b = binary.BigEndian.AppendUint64([]byte{'u'}, uint64(key))
+	case uint64:
+		b = binary.BigEndian.AppendUint64([]byte{'u'}, key)
+	default:
+		valueBytes, err := encodeKey(key)
+		if err != nil {
+			return [sha256.Size224]byte{}, err
+		}
+
+		b = append([]byte{'g'}, valueBytes...)
+	}
+
+	return hashBytes(b), nil
+}
+
 func hashBytes(b []byte) [sha256.Size224]byte {
 	return sha256.Sum224(b)
 }
@@ -43,8 +259,18 @@ func skip(r io.Reader, count int64) (err error) {
 	return err
 }
 
-func isFileExists(filePath string) (bool, error) {
-	if _, err := os.Stat(filePath); err == nil {
+// checkPointer returns ErrValueNotPointer if value can not be decoded into
+func checkPointer(value interface{}) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("%w: %T", ErrValueNotPointer, value)
+	}
+
+	return nil
+}
+
+func isFileExists(storage Storage, filePath string) (bool, error) {
+	if _, err := storage.Stat(filePath); err == nil {
 		return true, nil
 	} else if errors.Is(err, os.ErrNotExist) {
 		return false, nil
@@ -52,3 +278,8 @@ func isFileExists(filePath string) (bool, error) {
 		return false, err
 	}
 }
+
+// isTransientError reports whether failed file operation may succeed on retry
+func isTransientError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR)
+}
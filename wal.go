@@ -0,0 +1,137 @@
+package zkv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// openWAL replays records of write-ahead log left by interrupted process,
+// flushes them to store file and opens empty log for new records
+func (s *Store) openWAL() error {
+	err := s.replayWAL()
+	if err != nil {
+		return fmt.Errorf("replay write-ahead log: %w", err)
+	}
+
+	// Log is truncated only after its records are in store file
+	if s.buffer.Len() > 0 {
+		err = s.flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := s.options.Storage.Append(s.options.WALPath)
+	if err != nil {
+		return err
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.wal = f
+
+	return nil
+}
+
+// replayWAL writes records of write-ahead log to memory buffer.
+// Incomplete last record of interrupted write is ignored.
+func (s *Store) replayWAL() error {
+	f, err := s.options.Storage.Open(s.options.WALPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	reader := bytes.NewReader(b)
+	for reader.Len() > 0 {
+		_, record, err := readRecord(reader)
+		if err != nil {
+			break
+		}
+
+		switch record.Type {
+		case RecordTypeSet:
+			err = s.setRecord(record)
+		case RecordTypeDelete:
+			err = s.deleteHashed(record.KeyHash)
+		case RecordTypeTouch:
+			err = s.touch(record)
+		case RecordTypeMetadata:
+			err = s.readMetadata(record)
+			if err == nil {
+				err = s.writeRecord(record)
+			}
+		default:
+			err = s.writeRecord(record)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendWAL appends marshaled record to write-ahead log if it is enabled
+func (s *Store) appendWAL(b []byte) error {
+	if s.wal == nil {
+		return nil
+	}
+
+	_, err := s.wal.Write(b)
+	if err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+
+	if s.options.WALSync {
+		if syncer, ok := s.wal.(interface{ Sync() error }); ok {
+			err = syncer.Sync()
+			if err != nil {
+				return fmt.Errorf("write-ahead log: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// truncateWAL drops records of write-ahead log which are flushed
+// to store file or discarded
+func (s *Store) truncateWAL() error {
+	if s.wal == nil {
+		return nil
+	}
+
+	err := s.wal.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) closeWAL() error {
+	if s.wal == nil {
+		return nil
+	}
+
+	err := s.wal.Close()
+	s.wal = nil
+
+	return err
+}
@@ -3,13 +3,18 @@ package zkv
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"math"
+	"path/filepath"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 )
@@ -29,100 +34,261 @@ type Store struct {
 
 	options Options
 
+	// Store file format version
+	version byte
+
+	// Number of significant bytes of key hashes
+	keyHashSize int
+
+	closed bool
+
+	// Writes are forbidden by SetReadOnly
+	readOnly bool
+
+	// Number of records in store file and memory buffer
+	recordCount int64
+
+	// Number of records in memory buffer
+	bufferRecords int
+
+	// Buffer offsets of records overwritten with DedupBufferWrites option,
+	// they are dropped from buffer on flush
+	staleBufferOffsets map[int64]struct{}
+
+	// Sizes of known blocks
+	blockStats BlockStats
+
+	// Metadata of the latest metadata record
+	metadata map[string]string
+
+	// Compaction or other file rewrite is in progress,
+	// AutoCompact is suspended
+	compacting bool
+
+	// Damaged ranges skipped by index rebuild of BestEffortIndex option
+	skippedRanges []SkippedRange
+
+	// Optional filter of existing keys
+	bloomFilter *bloomFilter
+
+	// Optional write rate tracker for AdaptiveBuffer option
+	adaptiveBuffer *adaptiveBuffer
+
+	// Hash of last read or written index file
+	indexDigest [sha256.Size]byte
+
+	// Index file is behind store file
+	indexDirty bool
+
+	// Identifier of index log entries of current index file
+	indexLogID int64
+
+	// Keys deleted since last index log entry
+	deletedKeys map[string]struct{}
+
+	// Expiration of touched keys set by the latest touch records
+	touches map[string]int64
+
+	// Touches since last index log entry
+	pendingTouches map[string]int64
+
 	readOrderChan chan struct{}
 
+	// Queue of AsyncWrites mode operations
+	writeQueue       chan func() error
+	writeQueueMu     sync.RWMutex
+	writeQueueClosed bool
+	writerDone       chan struct{}
+
+	// First error of queued writes and expired keys reaper not reported yet
+	asyncErr error
+
+	// Errors of queued writes and expired keys reaper returned by Errors
+	errorsChan chan error
+
+	// Write-ahead log of WALPath option
+	wal AppendFile
+
+	// Held for reading by snapshots, store file replacement waits for it
+	snapshotMu sync.RWMutex
+
+	// Writers of TailTo receiving flushed blocks
+	tails map[*tailWriter]struct{}
+
+	// Store file append handle kept by KeepWriteHandleOpen option
+	// and size of store file written through it
+	writeFile   AppendFile
+	writeOffset int64
+
+	// Expired keys reaper of ExpireReaperInterval option
+	reaperStop     chan struct{}
+	reaperStopOnce sync.Once
+	reaperDone     chan struct{}
+
+	// Own encoder of flushed blocks if Encoder option is not set
+	encoder *zstd.Encoder
+
+	// Encoders and type definitions of StreamGob values
+	gobTypes *gobTypes
+
+	// Operation counters published by ExpvarName option
+	counters counters
+
+	// Pool of store file read handles
+	fileSlots chan struct{}
+	idleFiles chan io.ReadSeekCloser
+
 	mu sync.RWMutex
 }
 
 func OpenWithOptions(filePath string, options Options) (*Store, error) {
 	options.setDefaults()
 
+	if options.KeyHashBits != 128 && options.KeyHashBits != 8*sha256.Size224 {
+		return nil, fmt.Errorf("%w: key hash of %d bits", ErrNotSupported, options.KeyHashBits)
+	}
+
+	if options.MaxValueChunkSize < 0 || options.MaxValueChunkSize > 0 && options.MaxValueChunkSize < minValueChunkSize {
+		return nil, fmt.Errorf("wrong value chunk size %d, minimum is %d", options.MaxValueChunkSize, minValueChunkSize)
+	}
+
 	store := &Store{
-		dataOffset:       make(map[string]Offsets),
-		bufferDataOffset: make(map[string]int64),
-		buffer:           new(bytes.Buffer),
-		filePath:         filePath,
-		options:          options,
-		readOrderChan:    make(chan struct{}, int(options.MaxParallelReads))}
-
-	if options.useIndexFile {
-		idxFile, err := os.Open(filePath + indexFileExt)
-		if err == nil {
-			err = gob.NewDecoder(idxFile).Decode(&store.dataOffset)
-			if err != nil {
-				return nil, err
-			}
+		dataOffset:         make(map[string]Offsets, options.ExpectedKeys),
+		touches:            make(map[string]int64),
+		bufferDataOffset:   make(map[string]int64),
+		staleBufferOffsets: make(map[int64]struct{}),
+		buffer:             bytes.NewBuffer(make([]byte, 0, options.MemoryBufferSize)),
+		filePath:           filePath,
+		options:            options,
+		readOrderChan:      make(chan struct{}, int(options.MaxParallelReads)),
+		fileSlots:          make(chan struct{}, options.MaxOpenFiles),
+		idleFiles:          make(chan io.ReadSeekCloser, options.MaxOpenFiles),
+		gobTypes:           newGobTypes(),
+		errorsChan:         make(chan error, options.ErrorsBufferSize),
+		readOnly:           options.readOnly}
+
+	if options.IndexWriteMode == IndexWriteIncremental {
+		store.deletedKeys = make(map[string]struct{})
+		store.pendingTouches = make(map[string]int64)
+	}
+
+	if options.ExpvarName != "" {
+		err := store.publishCounters(options.ExpvarName)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			return store, nil
+	var (
+		saved *manifest
+		err   error
+	)
+	if options.IntegrityManifest {
+		saved, err = store.readManifest()
+		if err == nil && saved != nil {
+			err = store.verifyFile(saved)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	exists, err := isFileExists(filePath)
+	err = store.load()
 	if err != nil {
 		return nil, err
 	}
 
-	if !exists {
-		return store, nil
+	if saved != nil {
+		err = store.verifyKeyCount(saved)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = store.rebuildIndex()
-	if err != nil {
-		return nil, err
+	if options.SchemaVersion > 0 {
+		err = store.checkSchemaVersion()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return store, nil
-}
-
-func Open(filePath string) (*Store, error) {
-	options := defaultOptions
-	return OpenWithOptions(filePath, options)
-}
+	if options.WALPath != "" {
+		err = store.openWAL()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-func (s *Store) Set(key, value interface{}) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if options.AdaptiveBuffer {
+		store.adaptiveBuffer = &adaptiveBuffer{threshold: options.MemoryBufferSize}
+	}
 
-	return s.set(key, value)
-}
+	if options.AsyncWrites {
+		store.startWriter()
+	}
 
-func (s *Store) Get(key, value interface{}) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	if options.ExpireReaperInterval > 0 {
+		store.startReaper()
+	}
 
-	return s.get(key, value)
+	return store, nil
 }
 
-func (s *Store) Delete(key interface{}) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	keyHash, err := hashInterface(key)
+// load reads format version, index and bloom filter of store file
+func (s *Store) load() error {
+	version, keyHashSize, err := readFileHeader(s.options.Storage, s.filePath)
 	if err != nil {
 		return err
 	}
+	s.version = version
 
-	record := &Record{
-		Type:    RecordTypeDelete,
-		KeyHash: keyHash,
+	// Key hash size is set once on open as keys are hashed without
+	// store lock, SwapFile accepts files of the same key hash size only
+	if s.keyHashSize == 0 {
+		s.keyHashSize = keyHashSize
 	}
 
-	b, err := record.Marshal()
-	if err != nil {
-		return err
+	// New store file gets key hash size of options
+	if s.keyHashSize == 0 {
+		s.keyHashSize = s.options.KeyHashBits / 8
+	}
+	if keyHashSize == 0 && s.keyHashSize < sha256.Size224 {
+		s.version = formatVersionKeyHashSize
 	}
 
-	delete(s.dataOffset, string(record.KeyHash[:]))
-	delete(s.bufferDataOffset, string(record.KeyHash[:]))
+	var indexLoaded bool
+	if s.options.IndexReader != nil {
+		err = s.importIndex(s.options.IndexReader)
+		if err != nil {
+			return err
+		}
+		indexLoaded = true
 
-	_, err = s.buffer.Write(b)
-	if err != nil {
-		return err
+		// Reader is consumed, index of replaced file is loaded as usual
+		s.options.IndexReader = nil
+	} else if s.options.useIndexFile {
+		indexLoaded, err = s.loadIndex()
+		if err != nil {
+			return err
+		}
 	}
 
-	if s.buffer.Len() > s.options.MemoryBufferSize {
-		err = s.flush()
+	if !indexLoaded {
+		exists, err := isFileExists(s.options.Storage, s.filePath)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			err = s.rebuildIndex()
+			if err != nil {
+				return err
+			}
+		}
+	}
 
+	if s.options.BloomFilter {
+		err = s.initBloomFilter(indexLoaded)
 		if err != nil {
 			return err
 		}
@@ -131,261 +297,1555 @@ func (s *Store) Delete(key interface{}) error {
 	return nil
 }
 
-func (s *Store) Flush() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	return s.flush()
+func Open(filePath string) (*Store, error) {
+	options := defaultOptions
+	return OpenWithOptions(filePath, options)
 }
 
-func (s *Store) BackupWithOptions(filePath string, newFileOptions Options) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *Store) Set(key, value interface{}) error {
+	ctx, cancel := s.operationContext()
+	defer cancel()
 
-	err := s.flush()
-	if err != nil {
-		return err
-	}
+	return s.SetContext(ctx, key, value)
+}
 
-	newStore, err := OpenWithOptions(filePath, newFileOptions)
-	if err != nil {
-		return err
+// SetContext is Set which stops waiting for completion when context is
+// done. Operation may still be applied after context is done.
+func (s *Store) SetContext(ctx context.Context, key, value interface{}) error {
+	if s.writeQueue != nil {
+		keyHash, valueBytes, err := s.encodeSet(key, value)
+		if err != nil {
+			return err
+		}
+		typeName := s.valueTypeName(value)
+
+		return runContext(ctx, func() error {
+			return s.enqueueWrite(func() error { return s.setValue(keyHash, valueBytes, typeName, 0) })
+		})
 	}
 
-	for keyHashStr := range s.dataOffset {
-		var keyHash [sha256.Size224]byte
-		copy(keyHash[:], keyHashStr)
+	return runContext(ctx, func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
 
-		valueBytes, err := s.getGobBytes(keyHash)
-		if err != nil {
-			newStore.Close()
-			return err
+		if s.closed {
+			return ErrClosed
 		}
-		err = newStore.setBytes(keyHash, valueBytes)
-		if err != nil {
-			newStore.Close()
-			return err
+
+		if s.readOnly {
+			return ErrReadOnly
 		}
-	}
 
-	return newStore.Close()
+		return s.set(key, value)
+	})
 }
 
-func (s *Store) Backup(filePath string) error {
-	return s.BackupWithOptions(filePath, defaultOptions)
-}
+// SetRawHashed writes encoded value bytes by key hash. Hash must be
+// computed as by HashKey, otherwise value can not be read by original key.
+func (s *Store) SetRawHashed(keyHash [sha256.Size224]byte, valueBytes []byte) error {
+	valueBytes = append([]byte(nil), valueBytes...)
+
+	if s.writeQueue != nil {
+		return s.enqueueWrite(func() error { return s.setValue(keyHash, valueBytes, "", 0) })
+	}
 
-func (s *Store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	err := s.flush()
-	if err != nil {
-		return err
+	if s.closed {
+		return ErrClosed
 	}
 
-	return nil
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.setValue(keyHash, valueBytes, "", 0)
 }
 
-func (s *Store) setBytes(keyHash [sha256.Size224]byte, valueBytes []byte) error {
-	record, err := newRecordBytes(RecordTypeSet, keyHash, valueBytes)
+// GetByHash reads value by key hash as returned by HashKey or
+// ParseKeyHash. Hash bytes beyond key hash size of store are ignored.
+func (s *Store) GetByHash(keyHash [sha256.Size224]byte, value interface{}) error {
+	err := checkPointer(value)
 	if err != nil {
 		return err
 	}
 
-	b, err := record.Marshal()
+	s.mu.RLock()
+
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+
+	b, err := s.getHashed(s.truncateKeyHash(keyHash))
+	s.mu.RUnlock()
 	if err != nil {
 		return err
 	}
 
-	s.bufferDataOffset[string(record.KeyHash[:])] = int64(s.buffer.Len())
+	return decode(b, value)
+}
 
-	_, err = s.buffer.Write(b)
+// SetIfAbsent writes value only if key does not exist yet.
+// Returns true if value was written.
+func (s *Store) SetIfAbsent(key, value interface{}) (bool, error) {
+	keyHash, err := s.hashKey(key)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if s.buffer.Len() > s.options.MemoryBufferSize {
-		err = s.flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		if err != nil {
-			return err
-		}
+	if s.closed {
+		return false, ErrClosed
 	}
 
-	return nil
-}
+	if s.readOnly {
+		return false, ErrReadOnly
+	}
 
-func (s *Store) set(key, value interface{}) error {
-	record, err := newRecord(RecordTypeSet, key, value)
-	if err != nil {
-		return err
+	if s.exists(keyHash) {
+		record, err := s.getRecord(keyHash)
+		if err != nil {
+			return false, err
+		}
+
+		if !record.expired(time.Now()) {
+			return false, nil
+		}
 	}
 
-	b, err := record.Marshal()
+	err = s.set(key, value)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	s.bufferDataOffset[string(record.KeyHash[:])] = int64(s.buffer.Len())
+	return true, nil
+}
 
-	_, err = s.buffer.Write(b)
+func (s *Store) Get(key, value interface{}) error {
+	ctx, cancel := s.operationContext()
+	defer cancel()
+
+	return s.GetContext(ctx, key, value)
+}
+
+// GetContext is Get which stops waiting for completion when context is
+// done. Value is not modified after context is done.
+func (s *Store) GetContext(ctx context.Context, key, value interface{}) error {
+	err := checkPointer(value)
 	if err != nil {
 		return err
 	}
 
-	if s.buffer.Len() > s.options.MemoryBufferSize {
-		err = s.flush()
+	var b []byte
 
-		if err != nil {
-			return err
+	err = runContext(ctx, func() (err error) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		if s.closed {
+			return ErrClosed
 		}
+
+		b, err = s.get(key)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return decode(b, value)
 }
 
-func (s *Store) getGobBytes(keyHash [sha256.Size224]byte) ([]byte, error) {
-	s.readOrderChan <- struct{}{}
-	defer func() { <-s.readOrderChan }()
+// GetInto returns byte slice value of key decoded into buf, which is grown
+// only if it is too small. Reuse of returned slice as buf of next call
+// saves allocation of value. Applies to []byte and byte array values only.
+func (s *Store) GetInto(key interface{}, buf []byte) ([]byte, error) {
+	ctx, cancel := s.operationContext()
+	defer cancel()
 
-	offset, exists := s.bufferDataOffset[string(keyHash[:])]
-	if exists {
-		reader := bytes.NewReader(s.buffer.Bytes())
+	var b []byte
 
-		err := skip(reader, offset)
-		if err != nil {
-			return nil, err
-		}
+	err := runContext(ctx, func() (err error) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
 
-		_, record, err := readRecord(reader)
-		if err != nil {
-			return nil, err
+		if s.closed {
+			return ErrClosed
 		}
 
-		return record.ValueBytes, nil
+		b, err = s.get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	offsets, exists := s.dataOffset[string(keyHash[:])]
-	if !exists {
-		return nil, ErrNotExists
+	if v, ok := decodeBytesValue(b); ok {
+		return append(buf[:0], v...), nil
 	}
 
-	readF, err := os.Open(s.filePath)
+	var v []byte
+	err = decode(b, &v)
 	if err != nil {
 		return nil, err
 	}
-	defer readF.Close()
 
-	_, err = readF.Seek(offsets.BlockOffset, io.SeekStart)
+	return append(buf[:0], v...), nil
+}
+
+// Sources of values returned by GetSource
+const (
+	SourceBuffer = "buffer"
+	SourceDisk   = "disk"
+)
+
+// GetSource is Get which also reports whether value was read
+// from memory buffer (SourceBuffer) or from store file (SourceDisk)
+func (s *Store) GetSource(key, value interface{}) (source string, err error) {
+	err = checkPointer(value)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	decompressor, err := zstd.NewReader(readF)
+	keyHash, err := s.hashKey(key)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer decompressor.Close()
 
-	err = skip(decompressor, offsets.RecordOffset)
-	if err != nil {
-		return nil, err
+	s.mu.RLock()
+
+	if s.closed {
+		s.mu.RUnlock()
+		return "", ErrClosed
 	}
 
-	_, record, err := readRecord(decompressor)
-	if err != nil {
-		return nil, err
+	source = SourceDisk
+	if _, exists := s.bufferDataOffset[s.indexKey(keyHash)]; exists {
+		source = SourceBuffer
 	}
 
-	if !bytes.Equal(record.KeyHash[:], keyHash[:]) {
-		expectedHashStr := base64.StdEncoding.EncodeToString(keyHash[:])
-		gotHashStr := base64.StdEncoding.EncodeToString(record.KeyHash[:])
-		return nil, fmt.Errorf("wrong hash of offset %d: expected %s, got %s", offset, expectedHashStr, gotHashStr)
+	b, err := s.getHashed(keyHash)
+	s.mu.RUnlock()
+	if err != nil {
+		return "", err
 	}
 
-	return record.ValueBytes, nil
+	return source, decode(b, value)
 }
 
-func (s *Store) get(key, value interface{}) error {
-	s.readOrderChan <- struct{}{}
-	defer func() { <-s.readOrderChan }()
-
-	hashToFind, err := hashInterface(key)
+// Rename moves value of old key with its expiration to new key under
+// single lock, so readers see either old or new key. Existing value of
+// new key is overwritten. Returns ErrNotExists if old key does not exist.
+func (s *Store) Rename(oldKey, newKey interface{}) error {
+	oldKeyHash, err := s.hashKey(oldKey)
 	if err != nil {
 		return err
 	}
 
-	b, err := s.getGobBytes(hashToFind)
+	newKeyHash, err := s.hashKey(newKey)
 	if err != nil {
 		return err
 	}
 
-	return decode(b, value)
-}
-
-func (s *Store) flush() error {
-	l := int64(s.buffer.Len())
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	f, err := os.OpenFile(s.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("open store file: %v", err)
-	}
-	stat, err := f.Stat()
-	if err != nil {
-		f.Close()
-		return fmt.Errorf("stat store file: %v", err)
+	if s.closed {
+		return ErrClosed
 	}
 
-	diskWriteBuffer := bufio.NewWriterSize(f, s.options.DiskBufferSize)
+	if s.readOnly {
+		return ErrReadOnly
+	}
 
-	encoder, err := zstd.NewWriter(diskWriteBuffer, zstd.WithEncoderLevel(s.options.CompressionLevel))
-	if err != nil {
-		f.Close()
-		return fmt.Errorf("init encoder: %v", err)
+	if s.bloomFilter != nil && !s.bloomFilter.mayContain(oldKeyHash) {
+		return ErrNotExists
 	}
 
-	_, err = s.buffer.WriteTo(encoder)
+	record, err := s.getRecord(oldKeyHash)
 	if err != nil {
 		return err
 	}
 
-	for key, val := range s.bufferDataOffset {
-		s.dataOffset[key] = Offsets{BlockOffset: stat.Size(), RecordOffset: val}
+	if record.expired(time.Now()) {
+		return ErrNotExists
 	}
 
-	s.bufferDataOffset = make(map[string]int64)
-
-	err = encoder.Close()
-	if err != nil {
-		// TODO: truncate file to previous state
-		return err
+	if newKeyHash == oldKeyHash {
+		return nil
 	}
 
-	err = diskWriteBuffer.Flush()
+	// Chunks are keyed by key hash of value
+	if v, chunked := parseChunkedValue(record.ValueBytes); chunked {
+		err = s.copyChunks(oldKeyHash, newKeyHash, v)
+	} else if s.options.MaxValueChunkSize > 0 {
+		err = s.deleteChunks(newKeyHash, 0)
+	}
 	if err != nil {
-		// TODO: truncate file to previous state
 		return err
 	}
 
-	err = f.Close()
+	// Value bytes are moved as stored, without decoding
+	record.KeyHash = newKeyHash
+	err = s.setRecord(record)
 	if err != nil {
 		return err
 	}
 
-	// Update index file only on data update
-	if s.options.useIndexFile && l > 0 {
-		err = s.saveIndex()
+	return s.deleteHashed(oldKeyHash)
+}
+
+// HasMany reports existence of every given key
+func (s *Store) HasMany(keys []interface{}) ([]bool, error) {
+	keyHashes := make([][sha256.Size224]byte, 0, len(keys))
+	for _, key := range keys {
+		keyHash, err := s.hashKey(key)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		keyHashes = append(keyHashes, keyHash)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	result := make([]bool, len(keyHashes))
+	for i, keyHash := range keyHashes {
+		result[i] = s.exists(keyHash)
+	}
+
+	return result, nil
+}
+
+// GetRawRecord returns marshaled bytes of the latest key record
+// as it is written to store file
+func (s *Store) GetRawRecord(key interface{}) ([]byte, error) {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	record, err := s.getRecord(keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Marshal()
+}
+
+// Warm reads given keys in parallel to populate caches before
+// latency-sensitive work. Missing keys are ignored.
+func (s *Store) Warm(keys []interface{}) error {
+	keyHashes := make([][sha256.Size224]byte, 0, len(keys))
+	for _, key := range keys {
+		keyHash, err := s.hashKey(key)
+		if err != nil {
+			return err
+		}
+
+		keyHashes = append(keyHashes, keyHash)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	keyHashChan := make(chan [sha256.Size224]byte)
+	errChan := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < cap(s.readOrderChan); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for keyHash := range keyHashChan {
+				_, err := s.getGobBytes(keyHash)
+				if err != nil && !errors.Is(err, ErrNotExists) {
+					select {
+					case errChan <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	for _, keyHash := range keyHashes {
+		keyHashChan <- keyHash
+	}
+	close(keyHashChan)
+
+	wg.Wait()
+
+	select {
+	case err := <-errChan:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (s *Store) Delete(key interface{}) error {
+	ctx, cancel := s.operationContext()
+	defer cancel()
+
+	return s.DeleteContext(ctx, key)
+}
+
+// DeleteContext is Delete which stops waiting for completion when context
+// is done. Operation may still be applied after context is done.
+func (s *Store) DeleteContext(ctx context.Context, key interface{}) error {
+	if s.writeQueue != nil {
+		keyHash, err := s.hashKey(key)
+		if err != nil {
+			return err
+		}
+
+		return runContext(ctx, func() error {
+			return s.enqueueWrite(func() error { return s.deleteHashed(keyHash) })
+		})
+	}
+
+	return runContext(ctx, func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.closed {
+			return ErrClosed
+		}
+
+		if s.readOnly {
+			return ErrReadOnly
+		}
+
+		return s.delete(key)
+	})
+}
+
+func (s *Store) delete(key interface{}) error {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteHashed(keyHash)
+}
+
+func (s *Store) deleteHashed(keyHash [sha256.Size224]byte) error {
+	s.counters.deletes.Add(1)
+
+	if s.options.MaxValueChunkSize > 0 {
+		err := s.deleteChunks(keyHash, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.deleteRecord(keyHash)
+}
+
+// deleteRecord writes delete record of key hash
+func (s *Store) deleteRecord(keyHash [sha256.Size224]byte) error {
+	// Key without flushed records can be deleted by dropping its buffered
+	// set records. Buffered delete records are kept as they can hide
+	// flushed records.
+	if s.options.SkipBufferedTombstones {
+		_, buffered := s.bufferDataOffset[s.indexKey(keyHash)]
+		_, flushed := s.dataOffset[s.indexKey(keyHash)]
+		if buffered && !flushed {
+			// Log still needs delete record to not restore dropped records
+			b, err := (&Record{Type: RecordTypeDelete, KeyHash: keyHash}).Marshal()
+			if err != nil {
+				return err
+			}
+
+			err = s.appendWAL(b)
+			if err != nil {
+				return err
+			}
+
+			return s.removeBufferRecords(func(record *Record) bool {
+				return record.Type == RecordTypeSet && record.KeyHash == keyHash
+			})
+		}
+	}
+
+	record := &Record{
+		Type:      RecordTypeDelete,
+		KeyHash:   keyHash,
+		WrittenAt: s.writeTime(),
+	}
+
+	b, err := record.Marshal()
+	if err != nil {
+		return err
+	}
+
+	err = s.appendWAL(b)
+	if err != nil {
+		return err
+	}
+
+	delete(s.dataOffset, s.indexKey(record.KeyHash))
+	delete(s.bufferDataOffset, s.indexKey(record.KeyHash))
+	s.untouch(s.indexKey(record.KeyHash))
+	if s.deletedKeys != nil {
+		s.deletedKeys[s.indexKey(record.KeyHash)] = struct{}{}
+	}
+
+	_, err = s.buffer.Write(b)
+	if err != nil {
+		return err
+	}
+	s.recordCount++
+	s.bufferRecords++
+
+	return s.flushIfFull()
+}
+
+// WriteRecord writes record of custom type. Such records are not indexed,
+// they are passed to Options.RecordHandlers on index rebuild and are kept
+// by Backup and Compact.
+func (s *Store) WriteRecord(recordType RecordType, key, value interface{}) error {
+	if recordType == RecordTypeSet || recordType == RecordTypeDelete || recordType == RecordTypeTouch || recordType == RecordTypeMetadata {
+		return fmt.Errorf("%w: %d", ErrReservedRecordType, recordType)
+	}
+
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return err
+	}
+
+	valueBytes, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+
+	record, err := newRecordBytes(recordType, keyHash, valueBytes)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.writeRecord(record)
+}
+
+// writeRecord writes record to memory buffer without index update
+func (s *Store) writeRecord(record *Record) error {
+	b, err := record.Marshal()
+	if err != nil {
+		return err
+	}
+
+	err = s.appendWAL(b)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.buffer.Write(b)
+	if err != nil {
+		return err
+	}
+	s.recordCount++
+	s.bufferRecords++
+
+	return s.flushIfFull()
+}
+
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return s.flush()
+}
+
+// SetCompressionLevel changes compression level of next flushed blocks
+func (s *Store) SetCompressionLevel(level zstd.EncoderLevel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.options.CompressionLevel = level
+
+	// Encoder of new level is created on next flush
+	s.closeEncoder()
+}
+
+// PendingKeys returns hashes of keys written to memory buffer but not yet
+// flushed to disk
+func (s *Store) PendingKeys() [][sha256.Size224]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keyHashes := make([][sha256.Size224]byte, 0, len(s.bufferDataOffset))
+	for keyHashStr := range s.bufferDataOffset {
+		var keyHash [sha256.Size224]byte
+		copy(keyHash[:], keyHashStr)
+
+		keyHashes = append(keyHashes, keyHash)
+	}
+
+	return keyHashes
+}
+
+// BackupWithOptions copies live records to another file opened with
+// given options. Writes are not blocked during backup, records written
+// after its start are not copied.
+func (s *Store) BackupWithOptions(filePath string, newFileOptions Options) error {
+	return s.backup(filePath, newFileOptions, nil)
+}
+
+// BackupFiltered copies to another file only live records for which
+// keep returns true
+func (s *Store) BackupFiltered(filePath string, options Options, keep func(keyHash [sha256.Size224]byte, value []byte) (bool, error)) error {
+	return s.backup(filePath, options, keep)
+}
+
+// backup copies live records accepted by optional keep func to another
+// file. Records are copied from snapshot taken after flush, so writes
+// continue during backup while compaction waits for it.
+func (s *Store) backup(filePath string, newFileOptions Options, keep func(keyHash [sha256.Size224]byte, value []byte) (bool, error)) error {
+	snapshot, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.release()
+
+	// Records are copied without lock of new store, so it must not run
+	// expired keys reaper and async writes worker
+	newFileOptions.ExpireReaperInterval = 0
+	newFileOptions.AsyncWrites = false
+
+	newStore, err := OpenWithOptions(filePath, newFileOptions)
+	if err != nil {
+		return err
+	}
+
+	err = snapshot.forEachKeptRecord(func(record *Record) error {
+		// Value chunks and type definitions of StreamGob values are kept
+		// along with values
+		if keep != nil && record.Type == RecordTypeSet && !isInternalRecord(record.ValueBytes) {
+			ok, err := keep(record.KeyHash, record.ValueBytes)
+			if err != nil || !ok {
+				return err
+			}
+		}
+
+		if newFileOptions.BackupSkipUnchanged && record.Type == RecordTypeSet {
+			unchanged, err := newStore.hasRecord(record)
+			if err != nil || unchanged {
+				return err
+			}
+		}
+
+		return newStore.copyRecord(record)
+	})
+	if err != nil {
+		newStore.Close()
+		return err
+	}
+
+	return newStore.Close()
+}
+
+// hasRecord reports whether the latest set record of key has the same
+// value bytes and expiration as given record
+func (s *Store) hasRecord(record *Record) (bool, error) {
+	existing, err := s.getRecord(record.KeyHash)
+	if err != nil {
+		if errors.Is(err, ErrNotExists) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return existing.ExpireAt == record.ExpireAt && bytes.Equal(existing.ValueBytes, record.ValueBytes), nil
+}
+
+// copyLiveRecords copies only the latest versions of live records and
+// records of custom types to another store
+func (s *Store) copyLiveRecords(newStore *Store) error {
+	return s.forEachKeptRecord(newStore.copyRecord)
+}
+
+// copyRecord writes record read from another store
+func (s *Store) copyRecord(record *Record) error {
+	switch record.Type {
+	case RecordTypeSet:
+		return s.setRecord(record)
+	case RecordTypeTouch:
+		return s.touch(record)
+	}
+
+	return s.writeRecord(record)
+}
+
+// forEachKeptRecord calls fn for the latest versions of live flushed
+// records and for records of custom types reading the file sequentially
+// block by block
+func (s *Store) forEachKeptRecord(fn func(record *Record) error) error {
+	return s.scanRecords(s.keptRecords(fn))
+}
+
+// keptRecords returns scanRecords callback which calls fn only for the
+// latest versions of live records and for records of custom types
+func (s *Store) keptRecords(fn func(record *Record) error) func(offsets Offsets, record *Record) error {
+	return s.keptRecordsOf(s.dataOffset, s.touches, fn)
+}
+
+// keptRecordsOf is keptRecords with given index and touches
+func (s *Store) keptRecordsOf(dataOffset map[string]Offsets, touches map[string]int64, fn func(record *Record) error) func(offsets Offsets, record *Record) error {
+	return func(offsets Offsets, record *Record) error {
+		switch record.Type {
+		case RecordTypeSet:
+			if latestOffsets, exists := dataOffset[s.indexKey(record.KeyHash)]; !exists || latestOffsets != offsets {
+				return nil
+			}
+
+			// Touches are merged into copied record
+			if touchExpireAt, touched := touches[s.indexKey(record.KeyHash)]; touched {
+				record.ExpireAt = touchExpireAt
+			}
+
+			if record.expired(time.Now()) {
+				return nil
+			}
+		case RecordTypeDelete, RecordTypeTouch, RecordTypeMetadata:
+			return nil
+		}
+
+		return fn(record)
+	}
+}
+
+// BackupTo writes complete store file to writer
+func (s *Store) BackupTo(w io.Writer, options Options) error {
+	options.Storage = &writerStorage{w: w}
+
+	return s.BackupWithOptions(filepath.Base(s.filePath), options)
+}
+
+func (s *Store) Backup(filePath string) error {
+	return s.BackupWithOptions(filePath, defaultOptions)
+}
+
+// Close flushes data to disk. Store can not be used after close.
+// Repeated calls do nothing.
+func (s *Store) Close() error {
+	if s.writeQueue != nil {
+		s.stopWriter()
+	}
+
+	if s.reaperStop != nil {
+		s.stopReaper()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	// Failed queued writes are reported after shutdown
+	asyncErr := s.asyncErr
+	s.asyncErr = nil
+
+	if s.indexDirty {
+		err = s.saveIndex()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.options.AutoCompactOnClose && !s.readOnly && s.garbageRatio() > s.options.CompactRatioThreshold {
+		err = s.compact()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.options.IntegrityManifest {
+		err = s.writeManifest()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.closeWAL()
+	if err != nil {
+		return err
+	}
+
+	err = s.closeWriteFile()
+	if err != nil {
+		return err
+	}
+
+	s.closeIdleFiles()
+	s.closeEncoder()
+	for tail := range s.tails {
+		s.removeTail(tail)
+	}
+	s.closed = true
+
+	// Background operations are stopped
+	close(s.errorsChan)
+
+	return asyncErr
+}
+
+// setValue writes encoded value passing it through ValueEncodeHook.
+// Zero expireAt means no expiration.
+func (s *Store) setValue(keyHash [sha256.Size224]byte, valueBytes []byte, typeName string, expireAt int64) error {
+	err := s.storeGobType(valueBytes)
+	if err != nil {
+		return err
+	}
+
+	if s.options.ValueEncodeHook != nil {
+		valueBytes, err = s.options.ValueEncodeHook(valueBytes)
+		if err != nil {
+			return fmt.Errorf("value encode hook: %w", err)
+		}
+	}
+
+	s.counters.sets.Add(1)
+
+	if s.options.MaxValueChunkSize > 0 {
+		if len(valueBytes) > s.options.MaxValueChunkSize {
+			return s.setChunked(keyHash, bytes.NewReader(valueBytes), typeName, expireAt)
+		}
+
+		err = s.deleteChunks(keyHash, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.setRecord(&Record{Type: RecordTypeSet, KeyHash: keyHash, ValueBytes: valueBytes, ExpireAt: expireAt, TypeName: typeName, WrittenAt: s.writeTime()})
+}
+
+// setBytes writes value bytes as is
+func (s *Store) setBytes(keyHash [sha256.Size224]byte, valueBytes []byte) error {
+	record, err := newRecordBytes(RecordTypeSet, keyHash, valueBytes)
+	if err != nil {
+		return err
+	}
+
+	return s.setRecord(record)
+}
+
+// setRecord writes set record
+func (s *Store) setRecord(record *Record) error {
+	err := s.bufferSetRecord(record)
+	if err != nil {
+		return err
+	}
+
+	return s.flushIfFull()
+}
+
+// bufferSet writes set record to memory buffer without flush
+func (s *Store) bufferSet(keyHash [sha256.Size224]byte, valueBytes []byte) error {
+	record, err := newRecordBytes(RecordTypeSet, keyHash, valueBytes)
+	if err != nil {
+		return err
+	}
+
+	return s.bufferSetRecord(record)
+}
+
+// bufferSetRecord writes set record to memory buffer without flush
+func (s *Store) bufferSetRecord(record *Record) error {
+	b, err := record.Marshal()
+	if err != nil {
+		return err
+	}
+
+	err = s.appendWAL(b)
+	if err != nil {
+		return err
+	}
+
+	// Overwritten buffered record would be garbage right after flush
+	if offset, exists := s.bufferDataOffset[s.indexKey(record.KeyHash)]; exists && s.options.DedupBufferWrites {
+		s.staleBufferOffsets[offset] = struct{}{}
+		s.recordCount--
+		s.bufferRecords--
+	}
+
+	s.bufferDataOffset[s.indexKey(record.KeyHash)] = int64(s.buffer.Len())
+	s.untouch(s.indexKey(record.KeyHash))
+
+	_, err = s.buffer.Write(b)
+	if err != nil {
+		return err
+	}
+	s.recordCount++
+	s.bufferRecords++
+
+	if s.bloomFilter != nil {
+		s.addToBloomFilter(record.KeyHash)
+	}
+
+	return nil
+}
+
+func (s *Store) set(key, value interface{}) error {
+	keyHash, valueBytes, err := s.encodeSet(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.setValue(keyHash, valueBytes, s.valueTypeName(value), 0)
+}
+
+// encodeSet returns key hash and encoded value bytes of set operation
+func (s *Store) encodeSet(key, value interface{}) ([sha256.Size224]byte, []byte, error) {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return [sha256.Size224]byte{}, nil, err
+	}
+
+	if raw, ok := value.(RawValue); ok {
+		return keyHash, append([]byte(nil), raw...), nil
+	}
+
+	valueBytes, err := s.encodeStoreValue(value)
+	if err != nil {
+		return [sha256.Size224]byte{}, nil, err
+	}
+
+	if s.options.VerifyWrites {
+		b, _ := s.gobTypes.expand(valueBytes)
+		err = checkDecodable(b, value)
+		if err != nil {
+			return [sha256.Size224]byte{}, nil, fmt.Errorf("verify value: %w", err)
+		}
+	}
+
+	return keyHash, valueBytes, nil
+}
+
+// EstimateSize returns upper bound of size of set record of value in
+// memory buffer without writing it. Sum of sizes of values tells whether
+// their writes exceed MemoryBufferSize and trigger flush. Size does not
+// include type definitions of StreamGob option and chunk records of values
+// larger than MaxValueChunkSize. ValueEncodeHook is called on value as
+// on Set, so hook with side effects sees values which are never written.
+func (s *Store) EstimateSize(value interface{}) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	_, valueBytes, err := s.encodeSet(0, value)
+	if err != nil {
+		return 0, err
+	}
+
+	// Gob encodes bytes of key hash as unsigned integers taking
+	// one or two bytes, hash of the largest bytes gives upper bound
+	var keyHash [sha256.Size224]byte
+	for i := range keyHash {
+		keyHash[i] = 0xff
+	}
+
+	if s.options.ValueEncodeHook != nil {
+		valueBytes, err = s.options.ValueEncodeHook(valueBytes)
+		if err != nil {
+			return 0, fmt.Errorf("value encode hook: %w", err)
+		}
+	}
+
+	record := &Record{Type: RecordTypeSet, KeyHash: keyHash, ValueBytes: valueBytes, TypeName: s.valueTypeName(value), WrittenAt: s.writeTime()}
+
+	b, err := record.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// valueTypeName returns Go type name of value written with
+// StoreTypeNames option. Encoded RawValue has no known type.
+func (s *Store) valueTypeName(value interface{}) string {
+	if !s.options.StoreTypeNames {
+		return ""
+	}
+
+	valueType := reflect.TypeOf(value)
+	if _, ok := value.(RawValue); ok || valueType == nil {
+		return ""
+	}
+
+	return valueType.String()
+}
+
+// TypeOf returns Go type name of value of key as it was written with
+// StoreTypeNames option, for example "map[string]int". Returns empty
+// string for values written without type name.
+func (s *Store) TypeOf(key interface{}) (string, error) {
+	keyHash, err := s.hashKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return "", ErrClosed
+	}
+
+	record, err := s.getRecord(keyHash)
+	if err != nil {
+		return "", err
+	}
+
+	if record.expired(time.Now()) {
+		return "", ErrNotExists
+	}
+
+	return record.TypeName, nil
+}
+
+// HashKey returns hash of key as it is computed by store opened
+// with default options
+func HashKey(key interface{}) ([sha256.Size224]byte, error) {
+	return hashInterface(key)
+}
+
+// ParseKeyHash parses key hash written as hex or base64 string, for
+// example from logs or external index. Hashes truncated by KeyHashBits
+// option may be passed without trailing zero bytes.
+func ParseKeyHash(s string) ([sha256.Size224]byte, error) {
+	var keyHash [sha256.Size224]byte
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		b, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		b, err = base64.RawURLEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return keyHash, fmt.Errorf("key hash %q is neither hex nor base64", s)
+	}
+
+	if len(b) != sha256.Size224 && len(b) != 16 {
+		return keyHash, fmt.Errorf("wrong key hash length %d, expected %d or 16", len(b), sha256.Size224)
+	}
+	copy(keyHash[:], b)
+
+	return keyHash, nil
+}
+
+// HashKey returns hash of key as it is computed by store
+func (s *Store) HashKey(key interface{}) ([sha256.Size224]byte, error) {
+	return s.hashKey(key)
+}
+
+// hashKey returns hash of key according to PortableKeys option
+// truncated to key hash size of store
+func (s *Store) hashKey(key interface{}) ([sha256.Size224]byte, error) {
+	var (
+		keyHash [sha256.Size224]byte
+		err     error
+	)
+	if s.options.PortableKeys {
+		keyHash, err = hashPortableKey(key)
+	} else {
+		keyHash, err = hashInterface(key)
+	}
+
+	return s.truncateKeyHash(keyHash), err
+}
+
+// truncateKeyHash zeroes bytes of key hash beyond key hash size of store
+func (s *Store) truncateKeyHash(keyHash [sha256.Size224]byte) [sha256.Size224]byte {
+	for i := s.keyHashSize; i < len(keyHash); i++ {
+		keyHash[i] = 0
+	}
+
+	return keyHash
+}
+
+// indexKey returns key of index maps for key hash
+func (s *Store) indexKey(keyHash [sha256.Size224]byte) string {
+	return string(keyHash[:s.keyHashSize])
+}
+
+func (s *Store) exists(keyHash [sha256.Size224]byte) bool {
+	if _, exists := s.bufferDataOffset[s.indexKey(keyHash)]; exists {
+		return true
+	}
+
+	_, exists := s.dataOffset[s.indexKey(keyHash)]
+	return exists
+}
+
+// getGobBytes must be called under store read lock
+func (s *Store) getGobBytes(keyHash [sha256.Size224]byte) ([]byte, error) {
+	record, err := s.getRecord(keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.expired(time.Now()) {
+		return nil, ErrNotExists
+	}
+
+	return s.recordValue(record)
+}
+
+// recordValue returns encoded value of set record as it was passed to
+// ValueEncodeHook.
+// Must be called under store read lock.
+func (s *Store) recordValue(record *Record) ([]byte, error) {
+	valueBytes := record.ValueBytes
+
+	if v, chunked := parseChunkedValue(valueBytes); chunked {
+		var err error
+		valueBytes, err = s.joinChunks(record.KeyHash, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.options.ValueDecodeHook != nil {
+		var err error
+		valueBytes, err = s.options.ValueDecodeHook(valueBytes)
+		if err != nil {
+			return nil, fmt.Errorf("value decode hook: %w", err)
+		}
+	}
+
+	return s.expandGobValue(valueBytes)
+}
+
+// getRecord returns the latest set record of key with expiration
+// of its touches.
+// Must be called under store read lock.
+func (s *Store) getRecord(keyHash [sha256.Size224]byte) (*Record, error) {
+	record, err := s.getStoredRecord(keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	record.ExpireAt = s.touchedExpireAt(keyHash, record.ExpireAt)
+
+	return record, nil
+}
+
+// getStoredRecord returns the latest set record of key as it is stored.
+// Must be called under store read lock.
+func (s *Store) getStoredRecord(keyHash [sha256.Size224]byte) (*Record, error) {
+	offset, exists := s.bufferDataOffset[s.indexKey(keyHash)]
+	if exists {
+		// Gob decodes value bytes into new slice, so returned record
+		// does not alias memory buffer reset by flush or moved by growth
+		reader := bytes.NewReader(s.buffer.Bytes())
+
+		err := skip(reader, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		_, record, err := readRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+		s.counters.bufferHits.Add(1)
+
+		return record, nil
+	}
+
+	offsets, exists := s.dataOffset[s.indexKey(keyHash)]
+	if !exists {
+		return nil, ErrNotExists
+	}
+
+	record, err := s.readRecordAt(offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.indexKey(record.KeyHash) != s.indexKey(keyHash) {
+		expectedHashStr := base64.StdEncoding.EncodeToString(keyHash[:])
+		gotHashStr := base64.StdEncoding.EncodeToString(record.KeyHash[:])
+		return nil, fmt.Errorf("wrong hash of record at block offset %d, record offset %d: expected %s, got %s", offsets.BlockOffset, offsets.RecordOffset, expectedHashStr, gotHashStr)
+	}
+
+	return record, nil
+}
+
+// readRecordAt reads record of store file at given offsets
+func (s *Store) readRecordAt(offsets Offsets) (*Record, error) {
+	// Limit only concurrent disk reads, buffered records are served
+	// from memory
+	s.readOrderChan <- struct{}{}
+	defer func() { <-s.readOrderChan }()
+
+	readF, err := s.acquireFile()
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseFile(readF)
+
+	_, err = readF.Seek(offsets.BlockOffset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.readBlockRecord(readF, offsets.RecordOffset)
+	if err != nil {
+		return nil, fmt.Errorf("block at offset %d: %w", offsets.BlockOffset, err)
+	}
+
+	return record, nil
+}
+
+// readBlockRecord reads record at given offset of block starting
+// at current position of reader
+func (s *Store) readBlockRecord(r io.Reader, recordOffset int64) (*Record, error) {
+	blockReader := r
+	limit := int64(math.MaxInt64)
+	if s.version >= formatVersionBlockHeader {
+		header, err := readBlockHeader(r, s.version)
+		if err != nil {
+			return nil, err
+		}
+
+		blockReader = io.LimitReader(r, int64(header.CompressedSize))
+		limit = int64(header.UncompressedSize) - recordOffset
+
+		if header.Flags&blockFlagUncompressed != 0 {
+			err = skip(blockReader, recordOffset)
+			if err != nil {
+				return nil, err
+			}
+
+			n, record, err := readRecordLimited(blockReader, limit)
+			s.counters.bytesRead.Add(n)
+			return record, err
+		}
+	}
+
+	decompressor, err := s.options.DecoderPool.get(blockReader)
+	if err != nil {
+		return nil, err
+	}
+	defer s.options.DecoderPool.put(decompressor)
+
+	err = skip(decompressor, recordOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	n, record, err := readRecordLimited(decompressor, limit)
+	if err != nil {
+		return nil, err
+	}
+	s.counters.bytesRead.Add(n)
+
+	return record, nil
+}
+
+// get returns encoded bytes of key value.
+// Must be called under store read lock.
+func (s *Store) get(key interface{}) ([]byte, error) {
+	hashToFind, err := s.hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getHashed(hashToFind)
+}
+
+// getHashed returns encoded bytes of value of key hash.
+// Must be called under store read lock.
+func (s *Store) getHashed(keyHash [sha256.Size224]byte) ([]byte, error) {
+	s.counters.gets.Add(1)
+
+	if s.bloomFilter != nil && !s.bloomFilter.mayContain(s.truncateKeyHash(keyHash)) {
+		return nil, ErrNotExists
+	}
+
+	return s.getGobBytes(keyHash)
+}
+
+func (s *Store) flush() error {
+	// Empty buffer leaves store file untouched, so store without writes
+	// creates no file
+	if s.buffer.Len() == 0 {
+		return nil
+	}
+
+	// Records overwritten with DedupBufferWrites option are dropped in
+	// one pass over buffer
+	if len(s.staleBufferOffsets) > 0 {
+		err := s.removeBufferRecords(func(record *Record) bool { return false })
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		blockOffset, fileSize int64
+		err                   error
+	)
+	for attempt := 0; ; attempt++ {
+		blockOffset, fileSize, err = s.writeBlock()
+		if err == nil || attempt >= s.options.FlushRetries || !isTransientError(err) {
+			break
+		}
+
+		time.Sleep(s.options.FlushRetryBackoff << attempt)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Read handles of local files see appended blocks, handles of other
+	// storages may not
+	if _, local := s.options.Storage.(LocalStorage); !local {
+		s.closeIdleFiles()
+	}
+	s.writeTails()
+
+	setOffsets := make(map[string]Offsets, len(s.bufferDataOffset))
+	for key, val := range s.bufferDataOffset {
+		setOffsets[key] = Offsets{BlockOffset: blockOffset, RecordOffset: val}
+		s.dataOffset[key] = setOffsets[key]
+	}
+
+	s.blockStats.add(s.bufferRecords, fileSize-blockOffset)
+	s.counters.flushes.Add(1)
+	s.counters.bytesWritten.Add(fileSize - blockOffset)
+
+	s.buffer.Reset()
+	s.bufferDataOffset = make(map[string]int64)
+	s.staleBufferOffsets = make(map[int64]struct{})
+	s.bufferRecords = 0
+
+	err = s.truncateWAL()
+	if err != nil {
+		return err
+	}
+
+	if s.options.useIndexFile {
+		err = s.updateIndex(setOffsets, fileSize)
+		if err != nil {
+			return err
 		}
 	}
 
+	if s.options.AutoCompact {
+		return s.autoCompact()
+	}
+
 	return nil
 }
 
+// writeBlock appends memory buffer to store file as new block and returns
+// its offset and new file size. On write error file is truncated to its
+// previous state.
+func (s *Store) writeBlock() (blockOffset, fileSize int64, err error) {
+	f, size, err := s.openWriteFile()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	blockOffset = size
+
+	rollback := func(err error) error {
+		truncErr := f.Truncate(size)
+		s.releaseWriteFile(f, false)
+		if truncErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, truncErr)
+		}
+
+		return err
+	}
+
+	diskWriteBuffer := bufio.NewWriterSize(f, s.options.DiskBufferSize)
+
+	if blockOffset == 0 {
+		n, err := diskWriteBuffer.Write(fileHeader(s.version, s.keyHashSize))
+		if err != nil {
+			s.releaseWriteFile(f, false)
+			return 0, 0, err
+		}
+		blockOffset += int64(n)
+	}
+
+	header, data, err := s.encodeBlock(s.buffer.Bytes())
+	if err != nil {
+		s.releaseWriteFile(f, false)
+		return 0, 0, err
+	}
+
+	fileSize = blockOffset + int64(len(data))
+
+	// Empty buffer produces no block
+	if s.version >= formatVersionBlockHeader && len(data) > 0 {
+		_, err = diskWriteBuffer.Write(header.marshal(s.version))
+		if err != nil {
+			return 0, 0, rollback(err)
+		}
+		fileSize += int64(blockHeaderSize(s.version))
+	}
+
+	_, err = diskWriteBuffer.Write(data)
+	if err != nil {
+		return 0, 0, rollback(err)
+	}
+
+	err = diskWriteBuffer.Flush()
+	if err != nil {
+		return 0, 0, rollback(err)
+	}
+
+	// Block must be on disk before write-ahead log is truncated by flush
+	if s.wal != nil {
+		if syncer, ok := f.(interface{ Sync() error }); ok {
+			err = syncer.Sync()
+			if err != nil {
+				return 0, 0, rollback(err)
+			}
+		}
+	}
+	s.writeOffset = fileSize
+
+	err = s.releaseWriteFile(f, true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return blockOffset, fileSize, nil
+}
+
+// openWriteFile returns store file append handle and size of store file.
+// Handle kept by KeepWriteHandleOpen option is reused without stat.
+func (s *Store) openWriteFile() (AppendFile, int64, error) {
+	if s.writeFile != nil {
+		return s.writeFile, s.writeOffset, nil
+	}
+
+	f, err := s.options.Storage.Append(s.filePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("%w: %w", ErrStoreStat, err)
+	}
+
+	if s.options.KeepWriteHandleOpen {
+		s.writeFile, s.writeOffset = f, stat.Size()
+	}
+
+	return f, stat.Size(), nil
+}
+
+// releaseWriteFile closes append handle returned by openWriteFile unless
+// it is kept open. Kept handle is closed after failed write, so size of
+// store file is read again by next write.
+func (s *Store) releaseWriteFile(f AppendFile, written bool) error {
+	if f == s.writeFile {
+		if written {
+			return nil
+		}
+		s.writeFile = nil
+	}
+
+	return f.Close()
+}
+
+// closeWriteFile closes append handle kept by KeepWriteHandleOpen option.
+// Must be called before store file is replaced.
+func (s *Store) closeWriteFile() error {
+	if s.writeFile == nil {
+		return nil
+	}
+
+	err := s.writeFile.Close()
+	s.writeFile = nil
+
+	return err
+}
+
+// encodeBlock returns header and compressed data of block of records
+func (s *Store) encodeBlock(records []byte) (blockHeader, []byte, error) {
+	header := blockHeader{UncompressedSize: uint64(len(records))}
+
+	var data []byte
+	if s.version >= formatVersionBlockFlags && len(records) < s.options.MinCompressBlockSize {
+		header.Flags |= blockFlagUncompressed
+		data = records
+	} else {
+		encoder, err := s.blockEncoder()
+		if err != nil {
+			return blockHeader{}, nil, err
+		}
+		data = encoder.EncodeAll(records, nil)
+	}
+	header.CompressedSize = uint64(len(data))
+
+	return header, data, nil
+}
+
 func readBlock(r *bufio.Reader) (line []byte, n int, err error) {
-	delim := []byte{0x28, 0xb5, 0x2f, 0xfd}
+	delim := zstdMagic
 
 	line = make([]byte, len(delim))
 	copy(line, delim)
@@ -393,24 +1853,139 @@ func readBlock(r *bufio.Reader) (line []byte, n int, err error) {
 	for {
 		s, err := r.ReadBytes(delim[len(delim)-1])
 		line = append(line, []byte(s)...)
+		n += len(s)
 		if err != nil {
 			if bytes.Equal(line, delim) { // contains only magic number
 				return []byte{}, 0, err
 			} else {
-				return line, len(s), err
+				return line, n, err
 			}
 		}
 
 		if bytes.Equal(line, append(delim, delim...)) { // first block
 			line = make([]byte, len(delim))
 			copy(line, delim)
+			n = 0
 			continue
 		}
 
 		if bytes.HasSuffix(line, delim) {
-			return line[:len(line)-len(delim)], len(s), nil
+			return line[:len(line)-len(delim)], n, nil
+		}
+	}
+}
+
+// scanRecords calls fn for every record of store file in write order
+func (s *Store) scanRecords(fn func(offsets Offsets, record *Record) error) error {
+	return s.scanRecordsTolerant(fn, nil)
+}
+
+// scanRecordsTolerant is scanRecords which passes errors of unreadable
+// blocks to onBlockError if it is set and continues with next block
+// when onBlockError returns nil. Records of damaged block read before
+// error are passed to fn. Block size is zero if it is unknown.
+func (s *Store) scanRecordsTolerant(fn func(offsets Offsets, record *Record) error, onBlockError func(blockOffset, blockSize int64, err error) error) error {
+	_, err := s.scanRecordsFrom(int64(len(fileHeader(s.version, s.keyHashSize))), fn, onBlockError)
+	return err
+}
+
+// scanRecordsFrom is scanRecordsTolerant which starts with block
+// at given offset. Returns offset where scan stopped: end of file or
+// offset of damaged block of unknown size.
+func (s *Store) scanRecordsFrom(blockOffset int64, fn func(offsets Offsets, record *Record) error, onBlockError func(blockOffset, blockSize int64, err error) error) (int64, error) {
+	f, err := s.options.Storage.Open(s.filePath)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+	defer f.Close()
+
+	var totalBytes int64
+	if s.options.ProgressFunc != nil {
+		stat, err := s.options.Storage.Stat(s.filePath)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrStoreStat, err)
+		}
+		totalBytes = stat.Size()
+	}
+
+	err = skip(f, blockOffset)
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+
+	dec, err := s.options.DecoderPool.get(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer s.options.DecoderPool.put(dec)
+
+	for {
+		data, n, err := s.readBlockData(r, dec, 0)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			err = fmt.Errorf("block at offset %d: %w", blockOffset, err)
+			if onBlockError == nil {
+				return 0, err
+			}
+
+			err = onBlockError(blockOffset, n, err)
+			if err != nil {
+				return 0, err
+			}
+
+			// Scan stops on block of unknown size
+			if n == 0 {
+				return blockOffset, nil
+			}
+
+			blockOffset += n
+			continue
+		}
+
+		reader := bytes.NewReader(data)
+		blockSize := n
+
+		var recordOffset int64
+		for reader.Len() > 0 {
+			n, record, err := readRecord(reader)
+			if err != nil {
+				err = fmt.Errorf("block at offset %d: %w", blockOffset, err)
+				if onBlockError == nil {
+					return 0, err
+				}
+
+				err = onBlockError(blockOffset, blockSize, err)
+				if err != nil {
+					return 0, err
+				}
+
+				break
+			}
+
+			err = fn(Offsets{BlockOffset: blockOffset, RecordOffset: recordOffset}, record)
+			if err != nil {
+				return 0, err
+			}
+
+			recordOffset += n
+		}
+
+		blockOffset += n
+
+		if s.options.ProgressFunc != nil && blockOffset < totalBytes {
+			s.options.ProgressFunc(blockOffset, totalBytes)
 		}
 	}
+
+	if s.options.ProgressFunc != nil {
+		s.options.ProgressFunc(totalBytes, totalBytes)
+	}
+
+	return blockOffset, nil
 }
 
 // RebuildIndex renews index from store file
@@ -418,6 +1993,10 @@ func (s *Store) RebuildIndex() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.closed {
+		return ErrClosed
+	}
+
 	err := s.rebuildIndex()
 	if err != nil {
 		return err
@@ -431,78 +2010,286 @@ func (s *Store) RebuildIndex() error {
 }
 
 func (s *Store) rebuildIndex() error {
-	f, err := os.Open(s.filePath)
+	s.dataOffset = make(map[string]Offsets, s.options.ExpectedKeys)
+	s.touches = make(map[string]int64)
+	s.recordCount = 0
+	s.blockStats = BlockStats{}
+	s.skippedRanges = nil
+	s.metadata = nil
+
+	stat, err := s.options.Storage.Stat(s.filePath)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrStoreStat, err)
 	}
-	defer f.Close()
 
-	r := bufio.NewReader(f)
+	var (
+		blockOffset  int64 = -1
+		blockRecords int
+	)
+	addBlock := func(nextBlockOffset int64) {
+		if blockRecords > 0 {
+			s.blockStats.add(blockRecords, nextBlockOffset-blockOffset)
+		}
+		blockRecords = 0
+	}
+
+	var onBlockError func(blockOffset, blockSize int64, err error) error
+	if s.options.BestEffortIndex {
+		onBlockError = func(damagedOffset, blockSize int64, err error) error {
+			if damagedOffset != blockOffset {
+				addBlock(damagedOffset)
+			}
+			s.skippedRanges = append(s.skippedRanges, SkippedRange{Offset: damagedOffset, Size: blockSize, Err: err})
+
+			return nil
+		}
+	}
 
-	var blockOffset int64
+	scan := func(offsets Offsets, record *Record) error {
+		s.recordCount++
 
-	s.dataOffset = make(map[string]Offsets)
+		if offsets.BlockOffset != blockOffset {
+			addBlock(offsets.BlockOffset)
+			blockOffset = offsets.BlockOffset
+		}
+		blockRecords++
+
+		return s.indexRecord(offsets, record)
+	}
 
+	// Best effort scan continues with next readable block after
+	// damaged block of unknown size
+	scanOffset := int64(len(fileHeader(s.version, s.keyHashSize)))
 	for {
-		l, n, err := readBlock(r)
+		scanOffset, err = s.scanRecordsFrom(scanOffset, scan, onBlockError)
 		if err != nil {
-			if err != io.EOF {
-				return err
-			} else if err == io.EOF && len(l) == 0 {
-				break
-			}
+			return err
+		}
+		if len(s.skippedRanges) == 0 {
+			break
+		}
+		skipped := &s.skippedRanges[len(s.skippedRanges)-1]
+		if skipped.Offset != scanOffset || skipped.Size != 0 {
+			break
 		}
 
-		dec, err := zstd.NewReader(bytes.NewReader(l))
+		nextBlockOffset, err := s.findBlock(scanOffset, stat.Size())
+		if err != nil {
+			return err
+		}
+		skipped.Size = nextBlockOffset - scanOffset
+		scanOffset = nextBlockOffset
+	}
+	addBlock(stat.Size())
 
-		var recordOffset int64
-		for {
-			n, record, err := readRecord(dec)
-			if err != nil {
-				if err == io.EOF {
-					break
-				} else {
-					return err
-				}
-			}
+	if s.bloomFilter != nil {
+		s.rebuildBloomFilter()
+	}
+
+	return s.saveIndex()
+}
+
+// indexRecord applies record of store file at given offsets to index
+func (s *Store) indexRecord(offsets Offsets, record *Record) error {
+	switch record.Type {
+	case RecordTypeSet:
+		s.dataOffset[s.indexKey(record.KeyHash)] = offsets
+		delete(s.touches, s.indexKey(record.KeyHash))
+	case RecordTypeDelete:
+		delete(s.dataOffset, s.indexKey(record.KeyHash))
+		delete(s.touches, s.indexKey(record.KeyHash))
+	case RecordTypeTouch:
+		if _, exists := s.dataOffset[s.indexKey(record.KeyHash)]; exists {
+			s.touches[s.indexKey(record.KeyHash)] = record.ExpireAt
+		}
+	case RecordTypeMetadata:
+		return s.readMetadata(record)
+	default:
+		if handler := s.options.RecordHandlers[record.Type]; handler != nil {
+			return handler(record)
+		}
+	}
+
+	return nil
+}
+
+// catchUpIndex adds records of blocks written after loaded index
+// to index
+func (s *Store) catchUpIndex(indexedSize int64) error {
+	_, err := s.scanRecordsFrom(indexedSize, func(offsets Offsets, record *Record) error {
+		s.recordCount++
+		return s.indexRecord(offsets, record)
+	}, nil)
+
+	return err
+}
+
+// loadIndex reads index file if it exists
+func (s *Store) loadIndex() (loaded bool, err error) {
+	idxFile, err := s.options.Storage.Open(s.filePath + indexFileExt)
+	if err != nil {
+		return false, nil
+	}
+	defer idxFile.Close()
+
+	idxBytes, err := io.ReadAll(idxFile)
+	if err != nil {
+		return false, err
+	}
 
-			switch record.Type {
-			case RecordTypeSet:
-				s.dataOffset[string(record.KeyHash[:])] = Offsets{BlockOffset: blockOffset, RecordOffset: recordOffset}
-			case RecordTypeDelete:
-				delete(s.dataOffset, string(record.KeyHash[:]))
+	dataOffset, touches, header, err := decodeIndex(idxBytes)
+	if err != nil {
+		if errors.Is(err, ErrIndexVersion) {
+			if s.options.IndexVersionPolicy == IndexVersionRebuild {
+				return false, nil
 			}
-			recordOffset += n
+
+			return false, err
 		}
 
-		blockOffset += int64(n)
+		// Damaged index, for example truncated by crash during its write,
+		// is rebuilt from store file. Error is reported without blocking
+		// as nobody reads Errors channel yet.
+		select {
+		case s.errorsChan <- fmt.Errorf("%w: %w", ErrCorruptIndex, err):
+		default:
+		}
+
+		return false, nil
+	}
+
+	logEntries, err := s.readIndexLog()
+	if err != nil {
+		return false, err
+	}
+
+	fileSize, replayed := header.FileSize, false
+	for _, entry := range logEntries {
+		if header.LogID == 0 || entry.LogID != header.LogID {
+			continue
+		}
+
+		for _, key := range entry.Deleted {
+			delete(dataOffset, key)
+			delete(touches, key)
+		}
+		for key, offsets := range entry.Set {
+			dataOffset[key] = offsets
+			delete(touches, key)
+		}
+		for key, expireAt := range entry.Touched {
+			touches[key] = expireAt
+		}
+		if entry.Metadata != nil {
+			header.Metadata = entry.Metadata
+		}
+		fileSize, replayed = entry.FileSize, true
+	}
+
+	// Index of larger file does not match store file
+	var behind bool
+	if header.FileSize > 0 {
+		stat, err := s.options.Storage.Stat(s.filePath)
+		if err != nil || stat.Size() < fileSize {
+			return false, nil
+		}
+		behind = stat.Size() > fileSize
+	}
+
+	s.dataOffset = dataOffset
+	s.touches = touches
+	s.metadata = header.Metadata
+	s.indexLogID = header.LogID
+
+	// Bloom filter saved with index file misses keys of index log
+	// and of blocks written after index
+	if s.options.BloomFilter && !replayed && !behind {
+		s.indexDigest = sha256.Sum256(idxBytes)
+	}
+
+	// Index holds no history so assume store has no stale records
+	s.recordCount = int64(len(s.dataOffset))
+
+	// Index left behind store file by interrupted process is completed
+	// by scan of blocks written after it, index is rebuilt if they
+	// can not be read
+	if behind {
+		err = s.catchUpIndex(fileSize)
+		if err != nil {
+			return false, nil
+		}
+
+		return true, s.saveIndex()
+	}
+
+	return true, nil
+}
+
+func (s *Store) saveIndex() error {
+	// Store opened read-only leaves files untouched
+	if s.options.readOnly {
+		return nil
+	}
+
+	header := indexHeader{LogID: time.Now().UnixNano(), Metadata: s.metadata}
+
+	stat, err := s.options.Storage.Stat(s.filePath)
+	if err == nil {
+		header.FileSize = stat.Size()
 	}
 
 	idxBuf := new(bytes.Buffer)
 
-	err = gob.NewEncoder(idxBuf).Encode(s.dataOffset)
+	err = encodeIndex(idxBuf, s.dataOffset, s.touches, header)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(s.filePath+indexFileExt, idxBuf.Bytes(), 0644)
+	f, err := s.options.Storage.Create(s.filePath + indexFileExt)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (s *Store) saveIndex() error {
-	f, err := os.OpenFile(s.filePath+indexFileExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	_, err = f.Write(idxBuf.Bytes())
 	if err != nil {
+		f.Close()
 		return err
 	}
 
-	err = gob.NewEncoder(f).Encode(s.dataOffset)
+	err = f.Close()
 	if err != nil {
 		return err
 	}
 
-	return f.Close()
+	s.indexDirty = false
+	s.indexLogID = header.LogID
+	if s.deletedKeys != nil {
+		s.deletedKeys = make(map[string]struct{})
+		s.pendingTouches = make(map[string]int64)
+	}
+
+	if s.options.IndexWriteMode == IndexWriteIncremental {
+		err = s.removeIndexLog()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.bloomFilter != nil {
+		s.indexDigest = sha256.Sum256(idxBuf.Bytes())
+
+		return s.saveBloomFilter()
+	}
+
+	return nil
+}
+
+// tempFilePath returns path for new scratch file in TempDir
+func (s *Store) tempFilePath() string {
+	dir := s.options.TempDir
+	if dir == "" {
+		dir = filepath.Dir(s.filePath)
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%s.%d.tmp", filepath.Base(s.filePath), time.Now().UnixNano()))
 }
@@ -2,10 +2,27 @@ package zkv
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -176,6 +193,7 @@ func TestBufferRead(t *testing.T) {
 		err = db.Set(i, i)
 		assert.NoError(t, err)
 	}
+	assert.NoError(t, db.checkConsistency())
 
 	for i := 1; i <= recordCount; i++ {
 		var gotValue int
@@ -215,6 +233,30 @@ func TestBufferRead(t *testing.T) {
 
 }
 
+func TestGetSingleParallelRead(t *testing.T) {
+	const filePath = "TestGetSingleParallelRead.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{MaxParallelReads: 1})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// Read slot is taken once per read
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
 func TestBackupBasic(t *testing.T) {
 	const filePath = "TestBackupBasic.zkv"
 	const newFilePath = "TestBackupBasic2.zkv"
@@ -410,3 +452,5230 @@ func TestRebuildIndex(t *testing.T) {
 		assert.Equal(t, i, gotValue)
 	}
 }
+
+func TestWarm(t *testing.T) {
+	const filePath = "TestWarm.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{MaxParallelReads: 4})
+	assert.NoError(t, err)
+
+	var keys []interface{}
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+
+		keys = append(keys, i)
+	}
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// missing keys must be ignored
+	keys = append(keys, recordCount+1)
+
+	err = db.Warm(keys)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestBackupMultipleBlocks(t *testing.T) {
+	const filePath = "TestBackupMultipleBlocks.zkv"
+	const newFilePath = "TestBackupMultipleBlocks2.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{MemoryBufferSize: 4 * 1024})
+	assert.NoError(t, err)
+
+	// random values produce compressed blocks with arbitrary bytes inside
+	rnd := rand.New(rand.NewSource(0))
+	values := make(map[int][]byte)
+	for i := 1; i <= recordCount; i++ {
+		value := make([]byte, 1024)
+		rnd.Read(value)
+		values[i] = value
+
+		err = db.Set(i, value)
+		assert.NoError(t, err)
+	}
+
+	err = db.Backup(newFilePath)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(newFilePath)
+	assert.NoError(t, err)
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+
+	assert.Len(t, db.dataOffset, recordCount)
+
+	for i := 1; i <= recordCount; i++ {
+		var gotValue []byte
+
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, values[i], gotValue)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestFlushOpenError(t *testing.T) {
+	const filePath = "TestFlushOpenError/not/exists.zkv"
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.ErrorIs(t, err, ErrStoreOpen)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestPendingKeys(t *testing.T) {
+	const filePath = "TestPendingKeys.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	keyHash, err := hashInterface(1)
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][28]byte{keyHash}, db.PendingKeys())
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	assert.Len(t, db.PendingKeys(), 0)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestTempDir(t *testing.T) {
+	const filePath = "TestTempDir.zkv"
+	tempDir := t.TempDir()
+
+	db, err := OpenWithOptions(filePath, Options{TempDir: tempDir})
+	assert.NoError(t, err)
+
+	assert.Equal(t, tempDir, filepath.Dir(db.tempFilePath()))
+}
+
+func TestFormatVersion(t *testing.T) {
+	const filePath = "TestFormatVersion.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, db.version)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, b[0])
+
+	err = os.Remove(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	b[0] = 0xff
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+
+	_, err = Open(filePath)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestFormatVersionLegacy(t *testing.T) {
+	const filePath = "TestFormatVersionLegacy.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	b, err := os.ReadFile("testdata/TestReadBlock.zkv")
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersionLegacy, db.version)
+	assert.Len(t, db.dataOffset, 4)
+
+	err = db.Set(5, 5)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+	assert.Len(t, db.dataOffset, 5)
+
+	var gotValue int
+	err = db.Get(5, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	const filePath = "TestSetIfAbsent.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	written, err := db.SetIfAbsent(1, 1)
+	assert.NoError(t, err)
+	assert.True(t, written)
+
+	written, err = db.SetIfAbsent(1, 2)
+	assert.NoError(t, err)
+	assert.False(t, written)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	written, err = db.SetIfAbsent(1, 3)
+	assert.NoError(t, err)
+	assert.False(t, written)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	written, err = db.SetIfAbsent(1, 4)
+	assert.NoError(t, err)
+	assert.True(t, written)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+type countingStorage struct {
+	LocalStorage
+
+	opens   int
+	appends int
+}
+
+func (s *countingStorage) Open(name string) (io.ReadSeekCloser, error) {
+	s.opens++
+	return s.LocalStorage.Open(name)
+}
+
+func (s *countingStorage) Append(name string) (AppendFile, error) {
+	s.appends++
+	return s.LocalStorage.Append(name)
+}
+
+func TestCustomStorage(t *testing.T) {
+	const filePath = "TestCustomStorage.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	storage := new(countingStorage)
+
+	db, err := OpenWithOptions(filePath, Options{Storage: storage})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, storage.appends)
+
+	opens := storage.opens
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+	assert.Equal(t, opens+1, storage.opens)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.zkv")
+	dstPath := filepath.Join(dir, "dst.zkv")
+
+	err := os.WriteFile(dstPath, []byte("old"), 0644)
+	assert.NoError(t, err)
+
+	// Failed copy keeps destination file
+	err = copyFile(dir, dstPath)
+	assert.Error(t, err)
+
+	b, err := os.ReadFile(dstPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(b))
+
+	err = os.WriteFile(srcPath, []byte("new"), 0644)
+	assert.NoError(t, err)
+
+	err = copyFile(srcPath, dstPath)
+	assert.NoError(t, err)
+
+	b, err = os.ReadFile(dstPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(b))
+
+	// Temporary file is renamed over destination
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestProgressFunc(t *testing.T) {
+	const filePath = "TestProgressFunc.zkv"
+	const recordCount = 10
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	var calls int
+	var processed, total int64
+	progressFunc := func(bytesProcessed, totalBytes int64) {
+		assert.GreaterOrEqual(t, bytesProcessed, processed)
+		calls++
+		processed, total = bytesProcessed, totalBytes
+	}
+
+	db, err := OpenWithOptions(filePath, Options{ProgressFunc: progressFunc})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+
+	stat, err := os.Stat(filePath)
+	assert.NoError(t, err)
+
+	assert.NotZero(t, calls)
+	assert.Equal(t, stat.Size(), processed)
+	assert.Equal(t, stat.Size(), total)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestSetCompressionLevel(t *testing.T) {
+	const filePath = "TestSetCompressionLevel.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{CompressionLevel: zstd.SpeedBestCompression})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	db.SetCompressionLevel(zstd.SpeedFastest)
+	assert.Equal(t, zstd.SpeedFastest, db.options.CompressionLevel)
+
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+
+	for i := 1; i <= 2; i++ {
+		var gotValue int
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+type undecodableValue struct{}
+
+func (undecodableValue) GobEncode() ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (*undecodableValue) GobDecode([]byte) error {
+	return errors.New("undecodable")
+}
+
+func TestVerifyWrites(t *testing.T) {
+	const filePath = "TestVerifyWrites.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{VerifyWrites: true})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Set(2, undecodableValue{})
+	assert.Error(t, err)
+
+	assert.Len(t, db.bufferDataOffset, 1)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	const filePath = "TestCompact.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{TempDir: t.TempDir()})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		if i%2 == 0 {
+			err = db.Delete(i)
+		} else {
+			err = db.Set(i, i*10)
+		}
+		assert.NoError(t, err)
+	}
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	statBefore, err := os.Stat(filePath)
+	assert.NoError(t, err)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	statAfter, err := os.Stat(filePath)
+	assert.NoError(t, err)
+	assert.Less(t, statAfter.Size(), statBefore.Size())
+
+	assert.EqualValues(t, recordCount/2, db.recordCount)
+
+	checkValues := func(db *Store) {
+		assert.Len(t, db.dataOffset, recordCount/2)
+
+		for i := 1; i <= recordCount; i++ {
+			var gotValue int
+
+			err = db.Get(i, &gotValue)
+			if i%2 == 0 {
+				assert.ErrorIs(t, err, ErrNotExists)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, i*10, gotValue)
+			}
+		}
+	}
+
+	checkValues(db)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	checkValues(db)
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+
+	checkValues(db)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestAutoCompactOnClose(t *testing.T) {
+	const filePath = "TestAutoCompactOnClose.zkv"
+	const recordCount = 10
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{AutoCompactOnClose: true})
+	assert.NoError(t, err)
+
+	for j := 0; j < 3; j++ {
+		for i := 1; i <= recordCount; i++ {
+			err = db.Set(i, i)
+			assert.NoError(t, err)
+		}
+	}
+	assert.EqualValues(t, 3*recordCount, db.recordCount)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+	assert.EqualValues(t, recordCount, db.recordCount)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestGetRawRecord(t *testing.T) {
+	const filePath = "TestGetRawRecord.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	expectedRecord, err := newRecord(RecordTypeSet, 1, 1)
+	assert.NoError(t, err)
+
+	expectedBytes, err := expectedRecord.Marshal()
+	assert.NoError(t, err)
+
+	b, err := db.GetRawRecord(1)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedBytes, b)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	b, err = db.GetRawRecord(1)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedBytes, b)
+
+	_, err = db.GetRawRecord(2)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestBloomFilter(t *testing.T) {
+	const filePath = "TestBloomFilter.zkv"
+	const recordCount = 2000
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(filePath + bloomFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{BloomFilter: true})
+	assert.NoError(t, err)
+
+	// more than initial filter capacity to check growth
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	for i := 1; i <= recordCount; i++ {
+		var gotValue int
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	var falsePositives int
+	for i := recordCount + 1; i <= 2*recordCount; i++ {
+		keyHash, err := hashInterface(i)
+		assert.NoError(t, err)
+
+		if db.bloomFilter.mayContain(keyHash) {
+			falsePositives++
+		}
+
+		var gotValue int
+		err = db.Get(i, &gotValue)
+		assert.ErrorIs(t, err, ErrNotExists)
+	}
+	assert.Less(t, falsePositives, recordCount/20)
+
+	capacity := db.bloomFilter.Capacity
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = OpenWithOptions(filePath, Options{BloomFilter: true})
+	assert.NoError(t, err)
+
+	// filter must be loaded from file saved with index instead of rebuild
+	assert.Equal(t, capacity, db.bloomFilter.Capacity)
+
+	for i := 1; i <= recordCount; i += 100 {
+		var gotValue int
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestInMemory(t *testing.T) {
+	const filePath = "TestInMemory.zkv"
+	const recordCount = 100
+
+	db, err := OpenWithOptions(filePath, Options{InMemory: true, MemoryBufferSize: 100})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		var gotValue int
+		err = db.Get(i, &gotValue)
+		if i == 1 {
+			assert.ErrorIs(t, err, ErrNotExists)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, i, gotValue)
+		}
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filePath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filePath + indexFileExt)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestIndexVersion(t *testing.T) {
+	const filePath = "TestIndexVersion.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// legacy index file without header
+	f, err := os.Create(filePath + indexFileExt)
+	assert.NoError(t, err)
+	err = gob.NewEncoder(f).Encode(db.dataOffset)
+	assert.NoError(t, err)
+	err = f.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	assert.Len(t, db.dataOffset, 1)
+
+	// index file of unknown version
+	f, err = os.Create(filePath + indexFileExt)
+	assert.NoError(t, err)
+	err = gob.NewEncoder(f).Encode(indexHeader{Version: indexVersion + 1})
+	assert.NoError(t, err)
+	err = f.Close()
+	assert.NoError(t, err)
+
+	_, err = OpenWithOptions(filePath, Options{IndexVersionPolicy: IndexVersionError})
+	assert.ErrorIs(t, err, ErrIndexVersion)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	assert.Len(t, db.dataOffset, 1)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+}
+
+func TestBackupTo(t *testing.T) {
+	const filePath = "TestBackupTo.zkv"
+	const newFilePath = "TestBackupTo2.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{MemoryBufferSize: 100})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	f, err := os.Create(newFilePath)
+	assert.NoError(t, err)
+
+	err = db.BackupTo(f, Options{MemoryBufferSize: 100})
+	assert.NoError(t, err)
+
+	err = f.Close()
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(newFilePath)
+	assert.NoError(t, err)
+
+	assert.Len(t, db.dataOffset, recordCount-1)
+
+	for i := 2; i <= recordCount; i++ {
+		var gotValue int
+
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestHasMany(t *testing.T) {
+	const filePath = "TestHasMany.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+
+	result, err := db.HasMany([]interface{}{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, false}, result)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestClosed(t *testing.T) {
+	const filePath = "TestClosed.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = db.Set(2, 2)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	err = db.Delete(1)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestPortableKeys(t *testing.T) {
+	const filePath = "TestPortableKeys.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	keyHash, err := hashPortableKey("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, sha256.Sum224([]byte("sfoo")), keyHash)
+
+	keyHash, err = hashPortableKey(1)
+	assert.NoError(t, err)
+	assert.Equal(t, sha256.Sum224([]byte{'i', 0, 0, 0, 0, 0, 0, 0, 1}), keyHash)
+
+	keyHash2, err := hashPortableKey(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, keyHash, keyHash2)
+
+	db, err := OpenWithOptions(filePath, Options{PortableKeys: true})
+	assert.NoError(t, err)
+
+	err = db.Set("foo", 1)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	expectedHash := sha256.Sum224([]byte("sfoo"))
+	_, exists := db.dataOffset[string(expectedHash[:])]
+	assert.True(t, exists)
+
+	var gotValue int
+	err = db.Get("foo", &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestOperationTimeout(t *testing.T) {
+	const filePath = "TestOperationTimeout.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{OperationTimeout: 10 * time.Millisecond})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	// simulate stuck operation
+	db.mu.Lock()
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.SetContext(ctx, 2, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	db.mu.Unlock()
+
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestJSONL(t *testing.T) {
+	const filePath = "TestJSONL.zkv"
+	const newFilePath = "TestJSONL2.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	options := Options{StreamGob: true, MaxValueChunkSize: minValueChunkSize}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, fmt.Sprintf("value %d", i))
+		assert.NoError(t, err)
+	}
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	expireAt := time.Now().Add(time.Hour)
+	err = db.SetExpireAt(2, "expiring", expireAt)
+	assert.NoError(t, err)
+	err = db.SetExpireAt(3, "expired", time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+
+	largeValue := string(bytes.Repeat([]byte("x"), 4*minValueChunkSize))
+	err = db.Set(4, largeValue)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Export does not write index file of store
+	err = os.Remove(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	err = ExportJSONL(filePath, buf, options)
+	assert.NoError(t, err)
+	assert.Equal(t, recordCount-2, bytes.Count(buf.Bytes(), []byte("\n")))
+	assert.NoFileExists(t, filePath+indexFileExt)
+
+	err = ImportJSONL(newFilePath, buf, Options{})
+	assert.NoError(t, err)
+
+	db, err = Open(newFilePath)
+	assert.NoError(t, err)
+
+	assert.Len(t, db.dataOffset, recordCount-2)
+
+	var gotValue string
+	for i := 5; i <= recordCount; i++ {
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value %d", i), gotValue)
+	}
+
+	err = db.Get(2, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, "expiring", gotValue)
+
+	keyHash, err := db.HashKey(2)
+	assert.NoError(t, err)
+	record, err := db.getRecord(keyHash)
+	assert.NoError(t, err)
+	assert.Equal(t, expireAt.UnixNano(), record.ExpireAt)
+
+	err = db.Get(3, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	err = db.Get(4, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, largeValue, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = ImportJSONL(newFilePath, bytes.NewReader([]byte(`{"keyHash":"AQI=","value":"AQI="}`)), Options{})
+	assert.Error(t, err)
+}
+
+func TestAdaptiveBuffer(t *testing.T) {
+	const filePath = "TestAdaptiveBuffer.zkv"
+	const recordCount = 1000
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	options := Options{MemoryBufferSize: 1024, AdaptiveBuffer: true, AdaptiveBufferMaxSize: 8 * 1024, AdaptiveFlushInterval: time.Hour}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	// Threshold grows up to maximum on fast writes
+	assert.Equal(t, 8*1024, db.adaptiveBuffer.threshold)
+
+	// and shrinks on write after idle period
+	err = db.Flush()
+	assert.NoError(t, err)
+	db.adaptiveBuffer.lastWrite = time.Now().Add(-2 * time.Hour)
+	err = db.Set(0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4*1024, db.adaptiveBuffer.threshold)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 0; i <= recordCount; i++ {
+		var gotValue int
+
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestRawValue(t *testing.T) {
+	const filePath = "TestRawValue.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, "value")
+	assert.NoError(t, err)
+
+	var raw RawValue
+	err = db.Get(1, &raw)
+	assert.NoError(t, err)
+
+	expectedBytes, err := encode("value")
+	assert.NoError(t, err)
+	assert.Equal(t, RawValue(expectedBytes), raw)
+
+	err = db.Set(2, raw)
+	assert.NoError(t, err)
+
+	var gotValue string
+	err = db.Get(2, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestCluster(t *testing.T) {
+	const filePath = "TestCluster.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{MemoryBufferSize: 100})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	keys := []interface{}{10, 20, 30, 40, 10, recordCount + 1}
+	err = db.Cluster(keys)
+	assert.NoError(t, err)
+
+	blockOffset := db.dataOffset[string(mustHashKey(t, db, 10))].BlockOffset
+	for _, key := range []interface{}{20, 30, 40} {
+		assert.Equal(t, blockOffset, db.dataOffset[string(mustHashKey(t, db, key))].BlockOffset)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	assert.Len(t, db.dataOffset, recordCount)
+
+	for i := 1; i <= recordCount; i++ {
+		var gotValue int
+
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func mustHashKey(t *testing.T, db *Store, key interface{}) []byte {
+	keyHash, err := db.hashKey(key)
+	assert.NoError(t, err)
+
+	return keyHash[:]
+}
+
+func TestBlockHeader(t *testing.T) {
+	const filePath = "TestBlockHeader.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	bufferLen := db.buffer.Len()
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+
+	header, err := readBlockHeader(bytes.NewReader(b[1:]), formatVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(bufferLen), header.UncompressedSize)
+	assert.Equal(t, uint64(len(b)-1-blockHeaderSize(formatVersion)), header.CompressedSize)
+
+	// Corrupt uncompressed size
+	header.UncompressedSize = 1
+	copy(b[1:], header.marshal(formatVersion))
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.ErrorIs(t, err, ErrCorruptBlock)
+
+	err = db.RebuildIndex()
+	assert.ErrorIs(t, err, ErrCorruptBlock)
+}
+
+func TestHashKey(t *testing.T) {
+	keyHash, err := HashKey("key")
+	assert.NoError(t, err)
+
+	expectedHash, err := hashInterface("key")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedHash, keyHash)
+
+	db, err := OpenWithOptions("TestHashKey.zkv", Options{InMemory: true, PortableKeys: true})
+	assert.NoError(t, err)
+
+	keyHash, err = db.HashKey("key")
+	assert.NoError(t, err)
+
+	expectedHash, err = hashPortableKey("key")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedHash, keyHash)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestCheckConsistency(t *testing.T) {
+	db, err := OpenWithOptions("TestCheckConsistency.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Delete(5)
+	assert.NoError(t, err)
+
+	err = db.Set(3, 33)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.checkConsistency())
+
+	keyHash1, err := db.hashKey(1)
+	assert.NoError(t, err)
+	keyHash2, err := db.hashKey(2)
+	assert.NoError(t, err)
+
+	// Swap offsets of two keys
+	key1, key2 := string(keyHash1[:]), string(keyHash2[:])
+	db.bufferDataOffset[key1], db.bufferDataOffset[key2] = db.bufferDataOffset[key2], db.bufferDataOffset[key1]
+	assert.Error(t, db.checkConsistency())
+
+	db.bufferDataOffset[key1] = int64(db.buffer.Len())
+	assert.Error(t, db.checkConsistency())
+}
+
+func TestSkipBufferedTombstones(t *testing.T) {
+	const filePath = "TestSkipBufferedTombstones.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{SkipBufferedTombstones: true})
+	assert.NoError(t, err)
+
+	// Flushed key
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// Flushed and deleted key set again
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+	err = db.Delete(2)
+	assert.NoError(t, err)
+	err = db.Set(2, 22)
+	assert.NoError(t, err)
+
+	// Buffered only keys
+	for i := 3; i <= 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = db.Set(5, 55)
+	assert.NoError(t, err)
+
+	bufferLen := db.buffer.Len()
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+	assert.Greater(t, db.buffer.Len(), bufferLen)
+	bufferLen = db.buffer.Len()
+
+	for _, key := range []int{2, 5, 7} {
+		err = db.Delete(key)
+		assert.NoError(t, err)
+		assert.NoError(t, db.checkConsistency())
+	}
+	assert.Less(t, db.buffer.Len(), bufferLen)
+
+	// 2 flushed sets, buffered delete of key 2, 6 buffered sets
+	// and delete of key 1
+	assert.Equal(t, int64(10), db.recordCount)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = os.Remove(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		var gotValue int
+
+		err = db.Get(i, &gotValue)
+		if i == 1 || i == 2 || i == 5 || i == 7 {
+			assert.ErrorIs(t, err, ErrNotExists)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, i, gotValue)
+		}
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestSharded(t *testing.T) {
+	const recordCount = 100
+	dir := filepath.Join(t.TempDir(), "store")
+	backupDir := filepath.Join(t.TempDir(), "backup")
+
+	db, err := OpenSharded(dir, 4, Options{})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	for _, shard := range db.shards {
+		assert.NotEmpty(t, shard.bufferDataOffset)
+	}
+
+	err = db.Backup(backupDir)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	for _, dir := range []string{dir, backupDir} {
+		db, err = OpenSharded(dir, 4, Options{})
+		assert.NoError(t, err)
+
+		var gotValue int
+		err = db.Get(1, &gotValue)
+		assert.ErrorIs(t, err, ErrNotExists)
+
+		for i := 2; i <= recordCount; i++ {
+			err = db.Get(i, &gotValue)
+			assert.NoError(t, err)
+			assert.Equal(t, i, gotValue)
+		}
+
+		err = db.Close()
+		assert.NoError(t, err)
+	}
+
+	_, err = OpenSharded(dir, 0, Options{})
+	assert.Error(t, err)
+
+	// Keys of other shards count are routed to wrong files
+	_, err = OpenSharded(dir, 3, Options{})
+	assert.ErrorIs(t, err, ErrShardsMismatch)
+}
+
+func TestFastValue(t *testing.T) {
+	b, err := encodeValue(-5)
+	assert.NoError(t, err)
+	assert.Len(t, b, 3)
+
+	var gotInt8 int8
+	assert.NoError(t, decode(b, &gotInt8))
+	assert.Equal(t, int8(-5), gotInt8)
+
+	var gotUint uint
+	assert.Error(t, decode(b, &gotUint))
+
+	b, err = encodeValue(uint64(math.MaxUint64))
+	assert.NoError(t, err)
+
+	var gotInt64 int64
+	assert.Error(t, decode(b, &gotInt64))
+
+	var gotUint64 uint64
+	assert.NoError(t, decode(b, &gotUint64))
+	assert.Equal(t, uint64(math.MaxUint64), gotUint64)
+
+	b, err = encodeValue([4]byte{1, 2, 3, 4})
+	assert.NoError(t, err)
+
+	var gotArray [4]byte
+	assert.NoError(t, decode(b, &gotArray))
+	assert.Equal(t, [4]byte{1, 2, 3, 4}, gotArray)
+
+	var gotSlice []byte
+	assert.NoError(t, decode(b, &gotSlice))
+	assert.Equal(t, []byte{1, 2, 3, 4}, gotSlice)
+
+	var gotString string
+	assert.Error(t, decode(b, &gotString))
+
+	// Gob-encoded values of previous versions
+	b, err = encode(5)
+	assert.NoError(t, err)
+
+	var gotInt int
+	assert.NoError(t, decode(b, &gotInt))
+	assert.Equal(t, 5, gotInt)
+}
+
+func TestBackupFiltered(t *testing.T) {
+	const filePath = "TestBackupFiltered.zkv"
+	const newFilePath = "TestBackupFiltered2.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.BackupFiltered(newFilePath, Options{}, func(keyHash [sha256.Size224]byte, value []byte) (bool, error) {
+		var v int
+		err := decode(value, &v)
+		return v%2 == 0, err
+	})
+	assert.NoError(t, err)
+
+	errFilter := errors.New("filter error")
+	err = db.BackupFiltered(newFilePath+".tmp", Options{InMemory: true}, func(keyHash [sha256.Size224]byte, value []byte) (bool, error) {
+		return false, errFilter
+	})
+	assert.ErrorIs(t, err, errFilter)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(newFilePath)
+	assert.NoError(t, err)
+
+	assert.Len(t, db.dataOffset, recordCount/2)
+
+	for i := 1; i <= recordCount; i++ {
+		var gotValue int
+
+		err = db.Get(i, &gotValue)
+		if i%2 == 0 {
+			assert.NoError(t, err)
+			assert.Equal(t, i, gotValue)
+		} else {
+			assert.ErrorIs(t, err, ErrNotExists)
+		}
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestBackupFilteredStreamGob(t *testing.T) {
+	type Foo struct {
+		A int
+	}
+
+	const filePath = "TestBackupFilteredStreamGob.zkv"
+	const newFilePath = "TestBackupFilteredStreamGob2.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{StreamGob: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 1; i <= 10; i++ {
+		err = db.Set(i, Foo{A: i})
+		assert.NoError(t, err)
+	}
+
+	keyHash, err := db.HashKey(1)
+	assert.NoError(t, err)
+
+	// Filter by key does not drop type definitions
+	err = db.BackupFiltered(newFilePath, Options{}, func(k [sha256.Size224]byte, value []byte) (bool, error) {
+		return k == keyHash, nil
+	})
+	assert.NoError(t, err)
+
+	backup, err := Open(newFilePath)
+	assert.NoError(t, err)
+	defer backup.Close()
+
+	var got Foo
+	err = backup.Get(1, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, Foo{A: 1}, got)
+
+	err = backup.Get(2, &got)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestBlockErrorOffset(t *testing.T) {
+	const filePath = "TestBlockErrorOffset.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	blockOffset := db.dataOffset[string(mustHashKey(t, db, 2))].BlockOffset
+	assert.NotZero(t, blockOffset)
+
+	// Corrupt zstd magic number of second block
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	b[blockOffset+int64(blockHeaderSize(formatVersion))] = 0
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+
+	expectedPrefix := fmt.Sprintf("block at offset %d: ", blockOffset)
+
+	err = db.Get(2, &gotValue)
+	assert.ErrorContains(t, err, expectedPrefix)
+
+	err = db.RebuildIndex()
+	assert.ErrorContains(t, err, expectedPrefix)
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	const filePath = "TestMaxOpenFiles.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	storage := new(countingStorage)
+
+	db, err := OpenWithOptions(filePath, Options{Storage: storage, MaxParallelReads: 4, MaxOpenFiles: 1})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	opens := storage.opens
+
+	keys := make([]interface{}, 0, recordCount)
+	for i := 1; i <= recordCount; i++ {
+		keys = append(keys, i)
+	}
+
+	// Concurrent reads share single handle
+	err = db.Warm(keys)
+	assert.NoError(t, err)
+	assert.Equal(t, opens+1, storage.opens)
+
+	// Handles are reopened after flush
+	err = db.Set(0, 0)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	for i := 0; i <= recordCount; i++ {
+		var gotValue int
+
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+	assert.Equal(t, opens+2, storage.opens)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestIdleFilesKeptOnFlush(t *testing.T) {
+	const filePath = "TestIdleFilesKeptOnFlush.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Handle of local file sees appended blocks, so it is reused
+	var idleFile io.ReadSeekCloser
+	for i := 0; i < 3; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+		err = db.Flush()
+		assert.NoError(t, err)
+
+		var gotValue int
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+
+		f := <-db.idleFiles
+		if idleFile != nil {
+			assert.Same(t, idleFile, f)
+		}
+		idleFile = f
+		db.idleFiles <- f
+	}
+}
+
+func TestMinCompressBlockSize(t *testing.T) {
+	const filePath = "TestMinCompressBlockSize.zkv"
+	const recordCount = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{MinCompressBlockSize: 1024})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+
+		// Small blocks are not compressed, large are
+		if i == 1 || i == recordCount {
+			err = db.Flush()
+			assert.NoError(t, err)
+		}
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+
+	r := bytes.NewReader(b[1:])
+	header, err := readBlockHeader(r, formatVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, blockFlagUncompressed, header.Flags)
+	assert.Equal(t, header.UncompressedSize, header.CompressedSize)
+
+	_, err = r.Seek(int64(header.CompressedSize), io.SeekCurrent)
+	assert.NoError(t, err)
+
+	header, err = readBlockHeader(r, formatVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, blockFlags(0), header.Flags)
+	assert.Less(t, header.CompressedSize, header.UncompressedSize)
+
+	err = os.Remove(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		var gotValue int
+
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestFormatVersionBlockHeader(t *testing.T) {
+	const filePath = "TestFormatVersionBlockHeader.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	// Write file of previous format version
+	db.version = formatVersionBlockHeader
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = os.Remove(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersionBlockHeader, db.version)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestGetSource(t *testing.T) {
+	db, err := OpenWithOptions("TestGetSource.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+
+	var gotValue int
+	source, err := db.GetSource(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceDisk, source)
+	assert.Equal(t, 1, gotValue)
+
+	source, err = db.GetSource(2, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceBuffer, source)
+	assert.Equal(t, 2, gotValue)
+
+	_, err = db.GetSource(3, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestSwapFile(t *testing.T) {
+	const filePath = "TestSwapFile.zkv"
+	const newFilePath = "TestSwapFile2.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	newDb, err := Open(newFilePath)
+	assert.NoError(t, err)
+
+	for i := 101; i <= 110; i++ {
+		err = newDb.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = newDb.Close()
+	assert.NoError(t, err)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// Unflushed record is discarded
+	err = db.Set(11, 11)
+	assert.NoError(t, err)
+
+	err = db.SwapFile(newFilePath)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(newFilePath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	check := func() {
+		var gotValue int
+		for i := 1; i <= 11; i++ {
+			err = db.Get(i, &gotValue)
+			assert.ErrorIs(t, err, ErrNotExists)
+		}
+
+		for i := 101; i <= 110; i++ {
+			err = db.Get(i, &gotValue)
+			assert.NoError(t, err)
+			assert.Equal(t, i, gotValue)
+		}
+	}
+	check()
+
+	err = db.SwapFile(newFilePath)
+	assert.ErrorIs(t, err, ErrStoreStat)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	check()
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestSwapFileKeyHashSize(t *testing.T) {
+	const filePath = "TestSwapFileKeyHashSize.zkv"
+	const newFilePath = "TestSwapFileKeyHashSize2.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	newDb, err := OpenWithOptions(newFilePath, Options{KeyHashBits: 128})
+	assert.NoError(t, err)
+
+	err = newDb.Set(2, 2)
+	assert.NoError(t, err)
+
+	err = newDb.Close()
+	assert.NoError(t, err)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	err = db.SwapFile(newFilePath)
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	// Store keeps its file and new file is not removed
+	assert.FileExists(t, newFilePath)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Get(2, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestSwapFileIncrementalIndex(t *testing.T) {
+	const filePath = "TestSwapFileIncrementalIndex.zkv"
+	const newFilePath = "TestSwapFileIncrementalIndex2.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(filePath + indexLogFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	newDb, err := Open(newFilePath)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		err = newDb.Set(i, 100+i)
+		assert.NoError(t, err)
+	}
+
+	err = newDb.Close()
+	assert.NoError(t, err)
+
+	options := Options{IndexWriteMode: IndexWriteIncremental}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+
+	// Discarded deletes are not logged against new file
+	err = db.Delete(5)
+	assert.NoError(t, err)
+	err = db.Touch(6, -time.Second)
+	assert.NoError(t, err)
+
+	err = db.SwapFile(newFilePath)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filePath+indexLogFileExt)
+
+	err = db.Set(11, 11)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	check := func(db *Store) {
+		var gotValue int
+		for i := 1; i <= 10; i++ {
+			err = db.Get(i, &gotValue)
+			assert.NoError(t, err)
+			assert.Equal(t, 100+i, gotValue)
+		}
+	}
+	check(db)
+
+	// Reopen without Close as after crash
+	db2, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+	check(db2)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = db2.Close()
+	assert.NoError(t, err)
+}
+
+// indexRenameFailStorage fails renames of index files
+type indexRenameFailStorage struct {
+	LocalStorage
+}
+
+func (s indexRenameFailStorage) Rename(oldName, newName string) error {
+	if filepath.Ext(newName) == indexFileExt {
+		return errors.New("rename failed")
+	}
+
+	return s.LocalStorage.Rename(oldName, newName)
+}
+
+func TestSwapFileIndexRenameError(t *testing.T) {
+	const filePath = "TestSwapFileIndexRenameError.zkv"
+	const newFilePath = "TestSwapFileIndexRenameError2.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	newDb, err := Open(newFilePath)
+	assert.NoError(t, err)
+
+	for i := 101; i <= 110; i++ {
+		err = newDb.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = newDb.Close()
+	assert.NoError(t, err)
+
+	db, err := OpenWithOptions(filePath, Options{Storage: indexRenameFailStorage{}})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 1; i <= 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// Index of moved store file is rebuilt
+	err = db.SwapFile(newFilePath)
+	assert.Error(t, err)
+
+	var gotValue int
+	for i := 1; i <= 10; i++ {
+		err = db.Get(i, &gotValue)
+		assert.ErrorIs(t, err, ErrNotExists)
+	}
+
+	for i := 101; i <= 110; i++ {
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+}
+
+func TestGarbageBytes(t *testing.T) {
+	db, err := OpenWithOptions("TestGarbageBytes.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	garbageBytes, err := db.GarbageBytes()
+	assert.NoError(t, err)
+	assert.Zero(t, garbageBytes)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	garbageBytes, err = db.GarbageBytes()
+	assert.NoError(t, err)
+	assert.Zero(t, garbageBytes)
+
+	recordSize := func(recordType RecordType, key, value interface{}) int64 {
+		record, err := newRecord(RecordTypeSet, key, value)
+		assert.NoError(t, err)
+		if recordType == RecordTypeDelete {
+			record = &Record{Type: RecordTypeDelete, KeyHash: record.KeyHash}
+		}
+
+		b, err := record.Marshal()
+		assert.NoError(t, err)
+
+		return int64(len(b))
+	}
+
+	err = db.Set(1, 11)
+	assert.NoError(t, err)
+	err = db.Delete(2)
+	assert.NoError(t, err)
+
+	// Buffered records are not counted, flushed set of deleted key is
+	garbageBytes, err = db.GarbageBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, recordSize(RecordTypeSet, 2, 2), garbageBytes)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// Overwritten set of key 1, set of key 2 and its delete
+	garbageBytes, err = db.GarbageBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, recordSize(RecordTypeSet, 1, 1)+recordSize(RecordTypeSet, 2, 2)+recordSize(RecordTypeDelete, 2, 2), garbageBytes)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	garbageBytes, err = db.GarbageBytes()
+	assert.NoError(t, err)
+	assert.Zero(t, garbageBytes)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestCustomRecordType(t *testing.T) {
+	const filePath = "TestCustomRecordType.zkv"
+	const newFilePath = "TestCustomRecordType2.zkv"
+	const recordTypeNote RecordType = 10
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(newFilePath)
+	defer os.Remove(newFilePath + indexFileExt)
+
+	var notes []string
+	options := Options{RecordHandlers: map[RecordType]func(record *Record) error{
+		recordTypeNote: func(record *Record) error {
+			var note string
+			err := decode(record.ValueBytes, &note)
+			notes = append(notes, note)
+			return err
+		}}}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	err = db.WriteRecord(RecordTypeSet, 1, 1)
+	assert.ErrorIs(t, err, ErrReservedRecordType)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.WriteRecord(recordTypeNote, 1, "first")
+	assert.NoError(t, err)
+	err = db.Set(1, 2)
+	assert.NoError(t, err)
+	err = db.WriteRecord(recordTypeNote, 1, "second")
+	assert.NoError(t, err)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	err = db.Backup(newFilePath)
+	assert.NoError(t, err)
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, notes)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	notes = nil
+
+	err = os.Remove(newFilePath + indexFileExt)
+	assert.NoError(t, err)
+
+	db, err = OpenWithOptions(newFilePath, options)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, notes)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestRepair(t *testing.T) {
+	const filePath = "TestRepair.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	// Corrupt zstd magic number of second block and append truncated block
+	blockOffset := db.dataOffset[string(mustHashKey(t, db, 2))].BlockOffset
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	b[blockOffset+int64(blockHeaderSize(formatVersion))] = 0
+	b = append(b, 1, 2, 3)
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+
+	err = db.RebuildIndex()
+	assert.Error(t, err)
+
+	report, err := db.Repair()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.RecoveredRecords)
+	assert.Len(t, report.BlockErrors, 2)
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+
+	var gotValue int
+	for _, i := range []int{1, 3} {
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Get(2, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestAsyncWrites(t *testing.T) {
+	const filePath = "TestAsyncWrites.zkv"
+	const recordCount = 1000
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{AsyncWrites: true, MemoryBufferSize: 1024})
+	assert.NoError(t, err)
+
+	for i := 1; i <= recordCount; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	err = db.Sync()
+	assert.NoError(t, err)
+	assert.Zero(t, db.buffer.Len())
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	for i := 2; i <= recordCount; i++ {
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = db.Set(2, 2)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Errors of queued writes are returned by Sync
+	db, err = OpenWithOptions("TestAsyncWrites/not/exists.zkv", Options{AsyncWrites: true, MemoryBufferSize: 1})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Sync()
+	assert.ErrorIs(t, err, ErrStoreOpen)
+}
+
+func TestAsyncWritesCloseError(t *testing.T) {
+	const filePath = "TestAsyncWritesCloseError.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	hookErr := errors.New("hook error")
+	db, err := OpenWithOptions(filePath, Options{AsyncWrites: true, ValueEncodeHook: func(b []byte) ([]byte, error) {
+		if len(b) > 100 {
+			return nil, hookErr
+		}
+
+		return b, nil
+	}})
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Set(2, make([]byte, 1000))
+	assert.NoError(t, err)
+
+	// Store is closed completely before error of queued write is returned
+	err = db.Close()
+	assert.ErrorIs(t, err, hookErr)
+	if !assert.True(t, db.closed) {
+		return
+	}
+	assert.FileExists(t, filePath+indexFileExt)
+
+	// Errors channel is closed after reported errors
+	for range db.Errors() {
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	var got int
+	err = db.Get(1, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got)
+
+	assert.NoError(t, db.Close())
+}
+
+func TestIndexCount(t *testing.T) {
+	dataOffset := map[string]Offsets{"a": {BlockOffset: 1}, "b": {BlockOffset: 2}}
+
+	buf := new(bytes.Buffer)
+	err := encodeIndex(buf, dataOffset, nil, indexHeader{})
+	assert.NoError(t, err)
+
+	var header indexHeader
+	err = gob.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&header)
+	assert.NoError(t, err)
+	assert.Equal(t, len(dataOffset), header.Count)
+
+	gotDataOffset, _, _, err := decodeIndex(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, dataOffset, gotDataOffset)
+
+	// Header without count
+	buf.Reset()
+	encoder := gob.NewEncoder(buf)
+	err = encoder.Encode(struct{ Version byte }{Version: indexVersion})
+	assert.NoError(t, err)
+	err = encoder.Encode(dataOffset)
+	assert.NoError(t, err)
+
+	gotDataOffset, _, _, err = decodeIndex(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, dataOffset, gotDataOffset)
+}
+
+func TestSetRawHashed(t *testing.T) {
+	db, err := OpenWithOptions("TestSetRawHashed.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	keyHash, err := db.HashKey(1)
+	assert.NoError(t, err)
+
+	valueBytes, err := encodeValue("value")
+	assert.NoError(t, err)
+
+	err = db.SetRawHashed(keyHash, valueBytes)
+	assert.NoError(t, err)
+
+	// Value bytes are copied
+	valueBytes[len(valueBytes)-1] = 0
+
+	var gotValue string
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = db.SetRawHashed(keyHash, valueBytes)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestBlocks(t *testing.T) {
+	db, err := OpenWithOptions("TestBlocks.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+	assert.Empty(t, blocks)
+
+	for i := 1; i <= 3; i++ {
+		for j := 0; j < i; j++ {
+			err = db.Set(j, j)
+			assert.NoError(t, err)
+		}
+
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+
+	blocks, err = db.Blocks()
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 3)
+
+	stat, err := db.options.Storage.Stat(db.filePath)
+	assert.NoError(t, err)
+
+	offset := int64(len(fileHeader(db.version, db.keyHashSize)))
+	for i, block := range blocks {
+		assert.Equal(t, offset, block.Offset)
+		assert.Equal(t, i+1, block.RecordCount)
+		offset += block.Size
+	}
+	assert.Equal(t, stat.Size(), offset)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestIndexWriteMode(t *testing.T) {
+	for _, mode := range []IndexWriteMode{IndexWriteAlways, IndexWriteOnClose, IndexWriteIncremental} {
+		filePath := fmt.Sprintf("TestIndexWriteMode%d.zkv", mode)
+		defer os.Remove(filePath)
+		defer os.Remove(filePath + indexFileExt)
+		defer os.Remove(filePath + indexLogFileExt)
+
+		options := Options{IndexWriteMode: mode, MemoryBufferSize: 1}
+
+		db, err := OpenWithOptions(filePath, options)
+		assert.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			err = db.Set(i, i)
+			assert.NoError(t, err)
+		}
+		err = db.Delete(3)
+		assert.NoError(t, err)
+
+		_, err = os.Stat(filePath + indexLogFileExt)
+		assert.Equal(t, mode == IndexWriteIncremental, err == nil)
+
+		// Reopen without Close as after crash
+		db2, err := OpenWithOptions(filePath, options)
+		assert.NoError(t, err)
+		assert.Len(t, db2.dataOffset, 9)
+		assert.Equal(t, db.dataOffset, db2.dataOffset)
+
+		err = db.Close()
+		assert.NoError(t, err)
+
+		_, err = os.Stat(filePath + indexLogFileExt)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		db, err = OpenWithOptions(filePath, options)
+		assert.NoError(t, err)
+		assert.Len(t, db.dataOffset, 9)
+
+		var gotValue int
+		err = db.Get(9, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, 9, gotValue)
+
+		err = db.Get(3, &gotValue)
+		assert.ErrorIs(t, err, ErrNotExists)
+
+		err = db.Close()
+		assert.NoError(t, err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	db, err := OpenWithOptions("TestDigest1.zkv", Options{InMemory: true, MemoryBufferSize: 1})
+	assert.NoError(t, err)
+
+	db2, err := OpenWithOptions("TestDigest2.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	emptyDigest, err := db.Digest()
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+		err = db.Set(i, i+1)
+		assert.NoError(t, err)
+	}
+	err = db.Delete(0)
+	assert.NoError(t, err)
+
+	// Same contents written in other order and layout
+	for i := 9; i > 0; i-- {
+		err = db2.Set(i, i+1)
+		assert.NoError(t, err)
+	}
+
+	digest, err := db.Digest()
+	assert.NoError(t, err)
+	assert.NotEqual(t, emptyDigest, digest)
+
+	digest2, err := db2.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest, digest2)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	digest, err = db.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest2, digest)
+
+	err = db2.Set(1, 1)
+	assert.NoError(t, err)
+
+	digest2, err = db2.Digest()
+	assert.NoError(t, err)
+	assert.NotEqual(t, digest, digest2)
+}
+
+func TestForEachOrdered(t *testing.T) {
+	db, err := OpenWithOptions("TestForEachOrdered.zkv", Options{InMemory: true, MemoryBufferSize: 64})
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = db.Set(5, 50)
+	assert.NoError(t, err)
+	err = db.Delete(7)
+	assert.NoError(t, err)
+	err = db.Delete(3)
+	assert.NoError(t, err)
+	err = db.Set(3, 30)
+	assert.NoError(t, err)
+
+	var (
+		keys   []int
+		values []int
+	)
+	err = db.ForEachOrdered(func(keyHash [28]byte, value []byte) error {
+		for i := 0; i < 20; i++ {
+			if keyHash == [28]byte(mustHashKey(t, db, i)) {
+				keys = append(keys, i)
+			}
+		}
+
+		var v int
+		err := decode(value, &v)
+		values = append(values, v)
+
+		return err
+	})
+	assert.NoError(t, err)
+
+	expectedKeys := []int{0, 1, 2, 4, 5, 6, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 3}
+	assert.Equal(t, expectedKeys, keys)
+	for i, key := range keys {
+		switch key {
+		case 5:
+			assert.Equal(t, 50, values[i])
+		case 3:
+			assert.Equal(t, 30, values[i])
+		default:
+			assert.Equal(t, key, values[i])
+		}
+	}
+}
+
+func TestStreamGob(t *testing.T) {
+	const filePath = "TestStreamGob.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	type Value struct {
+		Name   string
+		Number int
+		Tags   []string
+		Attrs  map[string]int
+	}
+
+	value := func(i int) Value {
+		return Value{Name: fmt.Sprintf("name %d", i), Number: i, Tags: []string{"a", "b"}, Attrs: map[string]int{"x": i, "y": i}}
+	}
+
+	db, err := OpenWithOptions(filePath, Options{StreamGob: true, VerifyWrites: true})
+	assert.NoError(t, err)
+
+	plainDB, err := OpenWithOptions("TestStreamGobPlain.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i, value(i))
+		assert.NoError(t, err)
+
+		err = plainDB.Set(i, value(i))
+		assert.NoError(t, err)
+	}
+
+	// Size of streamed values excludes type definitions
+	var streamedSize, plainSize int
+	for i := 0; i < 100; i++ {
+		var raw RawValue
+		err = plainDB.Get(i, &raw)
+		assert.NoError(t, err)
+		plainSize += len(raw)
+
+		keyHash, err := db.HashKey(i)
+		assert.NoError(t, err)
+		record, err := db.getRecord(keyHash)
+		assert.NoError(t, err)
+		streamedSize += len(record.ValueBytes)
+	}
+	assert.Less(t, streamedSize, plainSize/2)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Type definitions are read from store file after reopen
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		var gotValue Value
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, value(i), gotValue)
+	}
+
+	// Raw value is complete gob stream readable by other stores
+	var raw RawValue
+	err = db.Get(1, &raw)
+	assert.NoError(t, err)
+	err = plainDB.Set("raw", raw)
+	assert.NoError(t, err)
+
+	var gotValue Value
+	err = plainDB.Get("raw", &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, value(1), gotValue)
+
+	count := 0
+	err = db.ForEachOrdered(func(keyHash [28]byte, value []byte) error {
+		count++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, count)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	err = db.Get(99, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, value(99), gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestSetReadOnly(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		db, err := OpenWithOptions("TestSetReadOnly.zkv", Options{InMemory: true, AsyncWrites: async})
+		assert.NoError(t, err)
+
+		err = db.Set(1, 1)
+		assert.NoError(t, err)
+
+		err = db.SetReadOnly()
+		assert.NoError(t, err)
+		assert.Zero(t, db.buffer.Len())
+
+		err = db.Set(2, 2)
+		assert.ErrorIs(t, err, ErrReadOnly)
+		err = db.Delete(1)
+		assert.ErrorIs(t, err, ErrReadOnly)
+		err = db.Flush()
+		assert.ErrorIs(t, err, ErrReadOnly)
+		err = db.Compact()
+		assert.ErrorIs(t, err, ErrReadOnly)
+
+		var gotValue int
+		err = db.Get(1, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, gotValue)
+
+		err = db.Close()
+		assert.NoError(t, err)
+	}
+}
+
+func TestValueHooks(t *testing.T) {
+	const filePath = "TestValueHooks.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	xor := func(b []byte) ([]byte, error) {
+		result := make([]byte, len(b))
+		for i := range b {
+			result[i] = b[i] ^ 0xAA
+		}
+
+		return result, nil
+	}
+	options := Options{ValueEncodeHook: xor, ValueDecodeHook: xor}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, fmt.Sprintf("value %d", i))
+		assert.NoError(t, err)
+	}
+
+	// Stored bytes are transformed
+	keyHash, err := db.HashKey(1)
+	assert.NoError(t, err)
+	record, err := db.getRecord(keyHash)
+	assert.NoError(t, err)
+	plainValueBytes, err := encodeValue("value 1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, plainValueBytes, record.ValueBytes)
+
+	err = db.ForEachOrdered(func(keyHash [28]byte, value []byte) error {
+		var gotValue string
+		return decode(value, &gotValue)
+	})
+	assert.NoError(t, err)
+
+	// Compaction copies stored bytes without hooks
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		var gotValue string
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value %d", i), gotValue)
+	}
+
+	failErr := errors.New("hook failed")
+	db.options.ValueDecodeHook = func([]byte) ([]byte, error) { return nil, failErr }
+
+	var gotValue string
+	err = db.Get(1, &gotValue)
+	assert.ErrorIs(t, err, failErr)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestPreallocate(t *testing.T) {
+	const filePath = "TestPreallocate.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Preallocate(1024 * 1024)
+	assert.NoError(t, err)
+
+	// Reserved space does not change file size
+	stat, err := os.Stat(filePath)
+	assert.NoError(t, err)
+	assert.Zero(t, stat.Size())
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	var gotValue int
+	err = db.Get(99, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 99, gotValue)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	const (
+		filePathA = "TestDiffA.zkv"
+		filePathB = "TestDiffB.zkv"
+	)
+	defer os.Remove(filePathA)
+	defer os.Remove(filePathA + indexFileExt)
+	defer os.Remove(filePathB)
+	defer os.Remove(filePathB + indexFileExt)
+
+	a, err := Open(filePathA)
+	assert.NoError(t, err)
+	b, err := Open(filePathB)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = a.Set(i, i)
+		assert.NoError(t, err)
+		err = b.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = a.Set(10, 10)
+	assert.NoError(t, err)
+	err = b.Set(11, 11)
+	assert.NoError(t, err)
+	err = b.Set(5, 50)
+	assert.NoError(t, err)
+	err = b.Delete(6)
+	assert.NoError(t, err)
+
+	// Expired keys are not live
+	err = b.SetExpireAt(7, 7, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+	err = a.SetExpireAt(12, 12, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+	err = b.SetExpireAt(13, 13, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+
+	hash := func(key int) [28]byte {
+		return [28]byte(mustHashKey(t, a, key))
+	}
+
+	err = a.Close()
+	assert.NoError(t, err)
+	err = b.Close()
+	assert.NoError(t, err)
+
+	// Stores are not written to
+	err = os.Remove(filePathB + indexFileExt)
+	assert.NoError(t, err)
+
+	onlyA, onlyB, differing, err := Diff(filePathA, filePathB)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filePathB+indexFileExt)
+	assert.ElementsMatch(t, [][28]byte{hash(6), hash(7), hash(10)}, onlyA)
+	assert.Equal(t, [][28]byte{hash(11)}, onlyB)
+	assert.Equal(t, [][28]byte{hash(5)}, differing)
+
+	onlyA, onlyB, differing, err = Diff(filePathA, filePathA)
+	assert.NoError(t, err)
+	assert.Empty(t, onlyA)
+	assert.Empty(t, onlyB)
+	assert.Empty(t, differing)
+}
+
+func TestSetExpireAt(t *testing.T) {
+	const filePath = "TestSetExpireAt.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.SetExpireAt(1, 1, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+	err = db.SetExpireAt(2, 2, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	var gotValue int
+	err = db.Get(1, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+	err = db.Get(2, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, gotValue)
+
+	written, err := db.SetIfAbsent(1, 10)
+	assert.NoError(t, err)
+	assert.True(t, written)
+	err = db.Get(1, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, gotValue)
+
+	err = db.SetExpireAt(3, 3, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// Compaction drops expired records and keeps expiration of others
+	err = db.Compact()
+	assert.NoError(t, err)
+	assert.Len(t, db.dataOffset, 2)
+
+	keyHash, err := db.HashKey(2)
+	assert.NoError(t, err)
+	record, err := db.getRecord(keyHash)
+	assert.NoError(t, err)
+	assert.NotZero(t, record.ExpireAt)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestExpireReaper(t *testing.T) {
+	db, err := OpenWithOptions("TestExpireReaper.zkv", Options{InMemory: true, ExpireReaperInterval: 10 * time.Millisecond})
+	assert.NoError(t, err)
+
+	err = db.SetExpireAt(1, 1, time.Now().Add(50*time.Millisecond))
+	assert.NoError(t, err)
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+	err = db.SetExpireAt(3, 3, time.Now())
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+
+		return len(db.dataOffset)+len(db.bufferDataOffset) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	var gotValue int
+	err = db.Get(2, &gotValue)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestCompactExpireReaper(t *testing.T) {
+	options := Options{InMemory: true, ExpireReaperInterval: time.Millisecond}
+	db, err := OpenWithOptions("TestCompactExpireReaper.zkv", options)
+	assert.NoError(t, err)
+
+	const recordCount = 5000
+	for i := 0; i < recordCount; i++ {
+		err = db.SetExpireAt(i, i, time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+	}
+
+	// Temporary and backup stores must not run reapers concurrently
+	// with copy of records
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	backupPath := filepath.Join(t.TempDir(), "backup.zkv")
+	err = db.BackupWithOptions(backupPath, Options{ExpireReaperInterval: time.Millisecond, AsyncWrites: true})
+	assert.NoError(t, err)
+
+	backup, err := Open(backupPath)
+	assert.NoError(t, err)
+	stats, err := backup.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, recordCount, stats.Keys)
+	assert.NoError(t, backup.Close())
+
+	stats, err = db.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, recordCount, stats.Keys)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestReadBlockAt(t *testing.T) {
+	db, err := OpenWithOptions("TestReadBlockAt.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 10; j++ {
+			err = db.Set(i*10+j, j)
+			assert.NoError(t, err)
+		}
+
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+
+	records, err := db.ReadBlockAt(blocks[1].Offset)
+	assert.NoError(t, err)
+	assert.Len(t, records, 10)
+	assert.Equal(t, mustHashKey(t, db, 10), records[0].KeyHash[:])
+
+	_, err = db.ReadBlockAt(blocks[1].Offset + 1)
+	assert.Error(t, err)
+
+	_, err = db.ReadBlockAt(0)
+	assert.Error(t, err)
+
+	// Size of uncompressed block is checked before read
+	db2, err := OpenWithOptions("TestReadBlockAt2.zkv", Options{InMemory: true, MinCompressBlockSize: 1024 * 1024})
+	assert.NoError(t, err)
+	defer db2.Close()
+
+	err = db2.Set(1, 1)
+	assert.NoError(t, err)
+	err = db2.Flush()
+	assert.NoError(t, err)
+
+	blocks, err = db2.Blocks()
+	assert.NoError(t, err)
+	data := db2.options.Storage.(*MemoryStorage).files["TestReadBlockAt2.zkv"].data
+	header := data[blocks[0].Offset:]
+	assert.Equal(t, blockFlagUncompressed, blockFlags(header[0]))
+	binary.LittleEndian.PutUint64(header[1:], maxReadBlockSize+1)
+	binary.LittleEndian.PutUint64(header[9:], maxReadBlockSize+1)
+
+	_, err = db2.ReadBlockAt(blocks[0].Offset)
+	assert.ErrorIs(t, err, ErrCorruptBlock)
+}
+
+func TestWAL(t *testing.T) {
+	const (
+		filePath = "TestWAL.zkv"
+		walPath  = "TestWAL.wal"
+	)
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(walPath)
+
+	options := Options{WALPath: walPath, WALSync: true}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	stat, err := os.Stat(walPath)
+	assert.NoError(t, err)
+	assert.Zero(t, stat.Size())
+
+	err = db.Set(10, 10)
+	assert.NoError(t, err)
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	stat, err = os.Stat(walPath)
+	assert.NoError(t, err)
+	assert.NotZero(t, stat.Size())
+
+	// Unflushed writes are restored from log as after crash
+	db2, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	var gotValue int
+	err = db2.Get(10, &gotValue)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, gotValue)
+
+	err = db2.Get(1, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	err = db2.Close()
+	assert.NoError(t, err)
+
+	stat, err = os.Stat(walPath)
+	assert.NoError(t, err)
+	assert.Zero(t, stat.Size())
+
+	db.wal.Close()
+}
+
+func TestMapKey(t *testing.T) {
+	for _, portableKeys := range []bool{false, true} {
+		db, err := OpenWithOptions("TestMapKey.zkv", Options{InMemory: true, PortableKeys: portableKeys})
+		assert.NoError(t, err)
+
+		type Key struct {
+			Name  string
+			Attrs map[string]int
+		}
+
+		for i := 0; i < 10; i++ {
+			key := map[string]int{"a": i, "b": 2, "c": 3, "d": 4, "e": 5}
+			err = db.Set(key, i)
+			assert.NoError(t, err)
+
+			err = db.Set(Key{Name: "name", Attrs: key}, i)
+			assert.NoError(t, err)
+		}
+
+		for attempt := 0; attempt < 10; attempt++ {
+			for i := 0; i < 10; i++ {
+				key := map[string]int{"e": 5, "d": 4, "c": 3, "b": 2, "a": i}
+
+				var gotValue int
+				err = db.Get(key, &gotValue)
+				assert.NoError(t, err)
+				assert.Equal(t, i, gotValue)
+
+				err = db.Get(Key{Name: "name", Attrs: key}, &gotValue)
+				assert.NoError(t, err)
+				assert.Equal(t, i, gotValue)
+			}
+		}
+
+		// Keys without maps of several entries keep gob-based hashes
+		for _, key := range []interface{}{
+			struct{ A int }{A: 1},
+			Key{Name: "name"},
+			Key{Name: "name", Attrs: map[string]int{}},
+			Key{Name: "name", Attrs: map[string]int{"a": 1}},
+			map[string]int{"a": 1},
+		} {
+			keyHash, err := db.HashKey(key)
+			assert.NoError(t, err)
+			b, err := encode(key)
+			assert.NoError(t, err)
+			if portableKeys {
+				b = append([]byte{'g'}, b...)
+			}
+			assert.Equal(t, hashBytes(b), keyHash)
+		}
+
+		err = db.Set(map[string]func(){"f": func() {}, "g": func() {}}, 1)
+		assert.ErrorIs(t, err, ErrUnhashableKey)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	const filePath = "TestTouch.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.SetExpireAt(1, 1, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+	err = db.Set(3, 3)
+	assert.NoError(t, err)
+
+	err = db.Touch(1, -time.Second)
+	assert.NoError(t, err)
+	err = db.Touch(2, time.Hour)
+	assert.NoError(t, err)
+	err = db.Touch(3, -time.Second)
+	assert.NoError(t, err)
+	err = db.Touch(4, time.Hour)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	// Set drops touches
+	err = db.Set(3, 30)
+	assert.NoError(t, err)
+
+	check := func() {
+		var gotValue int
+		err = db.Get(1, &gotValue)
+		assert.ErrorIs(t, err, ErrNotExists)
+
+		err = db.Get(2, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, gotValue)
+
+		keyHash, err := db.HashKey(2)
+		assert.NoError(t, err)
+		record, err := db.getRecord(keyHash)
+		assert.NoError(t, err)
+		assert.NotZero(t, record.ExpireAt)
+
+		err = db.Get(3, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, 30, gotValue)
+	}
+	check()
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Touches are kept in index file
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	check()
+
+	err = db.RebuildIndex()
+	assert.NoError(t, err)
+	check()
+
+	// Compaction merges touches into set records
+	err = db.Compact()
+	assert.NoError(t, err)
+	assert.Empty(t, db.touches)
+	check()
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestStats(t *testing.T) {
+	const filePath = "TestStats.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j <= i; j++ {
+			err = db.Set(i*10+j, j)
+			assert.NoError(t, err)
+		}
+
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+	err = db.Set(0, 1)
+	assert.NoError(t, err)
+
+	stats, err := db.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, 6, stats.Keys)
+	assert.EqualValues(t, 7, stats.Records)
+	assert.Equal(t, 3, stats.Blocks.Count)
+	assert.Equal(t, 1, stats.Blocks.MinRecords)
+	assert.Equal(t, 3, stats.Blocks.MaxRecords)
+	assert.Equal(t, 2.0, stats.Blocks.AvgRecords)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Stats of rebuilt index match blocks of store file
+	os.Remove(filePath + indexFileExt)
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	stats, err = db.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, len(blocks), stats.Blocks.Count)
+	assert.Equal(t, blocks[2].Size, stats.Blocks.MaxSize)
+	assert.Equal(t, 1, stats.Blocks.MinRecords)
+	assert.Equal(t, 3, stats.Blocks.MaxRecords)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func BenchmarkFlush(b *testing.B) {
+	db, err := OpenWithOptions("BenchmarkFlush.zkv", Options{InMemory: true})
+	assert.NoError(b, err)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("value "), 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = db.Set(i, value)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		err = db.Flush()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	const keyCount = 1000
+
+	db, err := OpenWithOptions("BenchmarkGet.zkv", Options{InMemory: true})
+	assert.NoError(b, err)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("value "), 100)
+	for i := 0; i < keyCount; i++ {
+		err = db.Set(i, value)
+		assert.NoError(b, err)
+	}
+	err = db.Flush()
+	assert.NoError(b, err)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var v []byte
+		err = db.Get(i%keyCount, &v)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSharedCodecs(t *testing.T) {
+	encoder, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	defer encoder.Close()
+
+	options := Options{InMemory: true, Encoder: encoder, DecoderPool: NewDecoderPool()}
+
+	db1, err := OpenWithOptions("TestSharedCodecs1.zkv", options)
+	assert.NoError(t, err)
+	defer db1.Close()
+
+	db2, err := OpenWithOptions("TestSharedCodecs2.zkv", options)
+	assert.NoError(t, err)
+	defer db2.Close()
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, db1.Set(i, i))
+		assert.NoError(t, db2.Set(i, -i))
+	}
+	assert.NoError(t, db1.Flush())
+	assert.NoError(t, db2.Flush())
+
+	for i := 0; i < 100; i++ {
+		var v1, v2 int
+		assert.NoError(t, db1.Get(i, &v1))
+		assert.NoError(t, db2.Get(i, &v2))
+		assert.Equal(t, i, v1)
+		assert.Equal(t, -i, v2)
+	}
+}
+
+func TestKeysSince(t *testing.T) {
+	db, err := OpenWithOptions("TestKeysSince.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	keyHashes, err := db.KeysSince(0)
+	assert.NoError(t, err)
+	assert.Empty(t, keyHashes)
+
+	assert.NoError(t, db.Set(1, 1))
+	assert.NoError(t, db.Set(2, 2))
+	assert.NoError(t, db.Flush())
+
+	assert.NoError(t, db.Set(3, 3))
+	assert.NoError(t, db.Delete(1))
+	assert.NoError(t, db.Set(3, 4))
+	assert.NoError(t, db.Flush())
+
+	// Unflushed record
+	assert.NoError(t, db.Set(4, 4))
+
+	hash := func(key int) [28]byte {
+		keyHash, err := db.hashKey(key)
+		assert.NoError(t, err)
+		return keyHash
+	}
+
+	keyHashes, err = db.KeysSince(0)
+	assert.NoError(t, err)
+	assert.Equal(t, [][28]byte{hash(1), hash(2), hash(3)}, keyHashes)
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+
+	keyHashes, err = db.KeysSince(blocks[1].Offset)
+	assert.NoError(t, err)
+	assert.Equal(t, [][28]byte{hash(3), hash(1)}, keyHashes)
+
+	keyHashes, err = db.KeysSince(blocks[1].Offset + blocks[1].Size)
+	assert.NoError(t, err)
+	assert.Empty(t, keyHashes)
+}
+
+func TestBestEffortIndex(t *testing.T) {
+	const filePath = "TestBestEffortIndex.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 4; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 4)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Corrupt compressed size of second block header to make its size unknown
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	binary.LittleEndian.PutUint64(b[blocks[1].Offset+1:], math.MaxUint32)
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+	os.Remove(filePath + indexFileExt)
+
+	_, err = Open(filePath)
+	assert.Error(t, err)
+
+	db, err = OpenWithOptions(filePath, Options{BestEffortIndex: true})
+	assert.NoError(t, err)
+
+	var gotValue int
+	for _, i := range []int{1, 3, 4} {
+		err = db.Get(i, &gotValue)
+		assert.NoError(t, err)
+		assert.Equal(t, i, gotValue)
+	}
+
+	err = db.Get(2, &gotValue)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	skippedRanges := db.SkippedRanges()
+	if assert.Len(t, skippedRanges, 1) {
+		assert.Equal(t, blocks[1].Offset, skippedRanges[0].Offset)
+		assert.Equal(t, blocks[1].Size, skippedRanges[0].Size)
+		assert.Error(t, skippedRanges[0].Err)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestKeyHashBits(t *testing.T) {
+	const filePath = "TestKeyHashBits.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	_, err := OpenWithOptions(filePath, Options{KeyHashBits: 256})
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	db, err := OpenWithOptions(filePath, Options{KeyHashBits: 128})
+	assert.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = db.Delete(10)
+	assert.NoError(t, err)
+
+	keyHash, err := db.HashKey(1)
+	assert.NoError(t, err)
+	assert.Equal(t, make([]byte, sha256.Size224-16), keyHash[16:])
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{formatVersionKeyHashSize, 16}, b[:2])
+
+	// Key hash size of store file is used regardless of options
+	for _, removeIndex := range []bool{false, true} {
+		if removeIndex {
+			os.Remove(filePath + indexFileExt)
+		}
+
+		db, err = Open(filePath)
+		assert.NoError(t, err)
+		assert.Equal(t, 16, db.keyHashSize)
+		for key := range db.dataOffset {
+			assert.Len(t, key, 16)
+		}
+
+		var gotValue int
+		for i := 1; i <= 9; i++ {
+			err = db.Get(i, &gotValue)
+			assert.NoError(t, err)
+			assert.Equal(t, i, gotValue)
+		}
+		err = db.Get(10, &gotValue)
+		assert.ErrorIs(t, err, ErrNotExists)
+
+		err = db.Compact()
+		assert.NoError(t, err)
+		assert.Equal(t, 16, db.keyHashSize)
+
+		err = db.Close()
+		assert.NoError(t, err)
+	}
+}
+
+func TestCompactRange(t *testing.T) {
+	const filePath = "TestCompactRange.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	blockOps := []func() error{
+		func() error { return errors.Join(db.Set(1, 1), db.Set(2, 2), db.Set(6, 6)) },
+		func() error { return errors.Join(db.Set(1, 10), db.Delete(2), db.Set(3, 3), db.Touch(6, -time.Hour)) },
+		func() error { return errors.Join(db.Set(1, 100), db.Set(4, 4), db.Delete(3)) },
+		func() error { return db.Set(5, 5) },
+	}
+	for _, ops := range blockOps {
+		assert.NoError(t, ops())
+		assert.NoError(t, db.Flush())
+	}
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 4)
+
+	err = db.CompactRange(blocks[1].Offset+1, blocks[3].Offset)
+	assert.Error(t, err)
+
+	err = db.CompactRange(blocks[1].Offset, blocks[3].Offset-1)
+	assert.Error(t, err)
+
+	err = db.CompactRange(blocks[1].Offset, blocks[3].Offset)
+	assert.NoError(t, err)
+
+	newBlocks, err := db.Blocks()
+	assert.NoError(t, err)
+	assert.Len(t, newBlocks, 3)
+	assert.Equal(t, blocks[0], newBlocks[0])
+	assert.Less(t, newBlocks[1].Size, blocks[1].Size+blocks[2].Size)
+
+	// Delete of key 2 and touch of key 6 hide records of first block
+	assert.Equal(t, 5, newBlocks[1].RecordCount)
+
+	check := func() {
+		expected := map[int]int{1: 100, 4: 4, 5: 5}
+		for key := 1; key <= 6; key++ {
+			var gotValue int
+			err := db.Get(key, &gotValue)
+			if value, exists := expected[key]; exists {
+				assert.NoError(t, err)
+				assert.Equal(t, value, gotValue)
+			} else {
+				assert.ErrorIs(t, err, ErrNotExists, key)
+			}
+		}
+	}
+	check()
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	os.Remove(filePath + indexFileExt)
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	check()
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestErrors(t *testing.T) {
+	db, err := OpenWithOptions("TestErrors/not/exists.zkv", Options{AsyncWrites: true, MemoryBufferSize: 1, ErrorsBufferSize: 2})
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	// Sync returns first error, channel keeps two errors
+	err = db.Sync()
+	assert.ErrorIs(t, err, ErrStoreOpen)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-db.Errors():
+			assert.ErrorIs(t, err, ErrStoreOpen)
+		default:
+			assert.Fail(t, "no background error")
+		}
+	}
+
+	select {
+	case err = <-db.Errors():
+		assert.Fail(t, "error is not dropped", err)
+	default:
+	}
+
+	db.options.Storage = NewMemoryStorage()
+	err = db.Close()
+	assert.NoError(t, err)
+
+	_, open := <-db.Errors()
+	assert.False(t, open)
+}
+
+func TestMetadata(t *testing.T) {
+	const filePath = "TestMetadata.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	metadata, err := db.GetMetadata()
+	assert.NoError(t, err)
+	assert.Nil(t, metadata)
+
+	err = db.SetMetadata(map[string]string{"schema": "1"})
+	assert.NoError(t, err)
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	expected := map[string]string{"schema": "2", "app": "test"}
+	err = db.SetMetadata(expected)
+	assert.NoError(t, err)
+
+	err = db.WriteRecord(RecordTypeMetadata, 1, 1)
+	assert.ErrorIs(t, err, ErrReservedRecordType)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	for _, removeIndex := range []bool{false, true} {
+		if removeIndex {
+			os.Remove(filePath + indexFileExt)
+		}
+
+		db, err = Open(filePath)
+		assert.NoError(t, err)
+
+		metadata, err = db.GetMetadata()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, metadata)
+		assert.Len(t, db.dataOffset, 1)
+
+		err = db.Close()
+		assert.NoError(t, err)
+	}
+
+	// Compaction keeps the latest metadata only
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	var metadataRecords int
+	err = db.scanRecords(func(_ Offsets, record *Record) error {
+		if record.Type == RecordTypeMetadata {
+			metadataRecords++
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, metadataRecords)
+
+	metadata, err = db.GetMetadata()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, metadata)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestValueNotPointer(t *testing.T) {
+	db, err := OpenWithOptions("TestValueNotPointer.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	var value int
+	var nilPointer *int
+	for _, dest := range []interface{}{value, nilPointer, nil} {
+		err = db.Get(1, dest)
+		assert.ErrorIs(t, err, ErrValueNotPointer)
+
+		_, err = db.GetSource(1, dest)
+		assert.ErrorIs(t, err, ErrValueNotPointer)
+	}
+
+	err = db.Get(1, &value)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+
+	type Foo struct{ A int }
+
+	err = db.Set(2, Foo{A: 2})
+	assert.NoError(t, err)
+
+	err = db.Get(2, (*Foo)(nil))
+	assert.ErrorIs(t, err, ErrValueNotPointer)
+
+	// Pointer to nil pointer is valid destination
+	var foo *Foo
+	err = db.Get(2, &foo)
+	assert.NoError(t, err)
+	assert.Equal(t, &Foo{A: 2}, foo)
+}
+
+func TestMergeFunc(t *testing.T) {
+	srcPaths := []string{"TestMergeFunc1.zkv", "TestMergeFunc2.zkv"}
+	const destPath = "TestMergeFunc.zkv"
+	for _, filePath := range append(srcPaths, destPath) {
+		defer os.Remove(filePath)
+		defer os.Remove(filePath + indexFileExt)
+	}
+
+	xor := func(b []byte) ([]byte, error) {
+		result := make([]byte, len(b))
+		for i := range b {
+			result[i] = b[i] ^ 0xAA
+		}
+
+		return result, nil
+	}
+	srcOptions := Options{ValueEncodeHook: xor, ValueDecodeHook: xor}
+
+	for i, srcPath := range srcPaths {
+		db, err := OpenWithOptions(srcPath, srcOptions)
+		assert.NoError(t, err)
+
+		// Keys 0-9 in first store and 5-14 in second one
+		for key := 5 * i; key < 5*i+10; key++ {
+			err = db.Set(key, 1)
+			assert.NoError(t, err)
+		}
+		err = db.Delete(5 * i)
+		assert.NoError(t, err)
+
+		err = db.Close()
+		assert.NoError(t, err)
+
+		// Sources are not written by merge
+		err = os.Remove(srcPath + indexFileExt)
+		assert.NoError(t, err)
+	}
+
+	var conflicts int
+	sum := func(key [28]byte, a, b []byte) ([]byte, error) {
+		conflicts++
+
+		var valueA, valueB int
+		err := errors.Join(decode(a, &valueA), decode(b, &valueB))
+		if err != nil {
+			return nil, err
+		}
+
+		return encodeValue(valueA + valueB)
+	}
+
+	err := MergeFunc(destPath, defaultOptions, srcOptions, sum, srcPaths...)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, conflicts)
+	for _, srcPath := range srcPaths {
+		assert.NoFileExists(t, srcPath+indexFileExt)
+	}
+
+	db, err := Open(destPath)
+	assert.NoError(t, err)
+
+	for key := 0; key < 15; key++ {
+		var value int
+		err = db.Get(key, &value)
+		switch {
+		case key == 0:
+			assert.ErrorIs(t, err, ErrNotExists)
+		case key >= 6 && key < 10:
+			assert.NoError(t, err)
+			assert.Equal(t, 2, value)
+		default:
+			assert.NoError(t, err)
+			assert.Equal(t, 1, value)
+		}
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	failure := errors.New("failure")
+	err = MergeFunc(destPath, defaultOptions, srcOptions, func(key [28]byte, a, b []byte) ([]byte, error) {
+		return nil, failure
+	}, srcPaths[0])
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestRecordAt(t *testing.T) {
+	db, err := OpenWithOptions("TestRecordAt.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		keyHash, err := db.HashKey(i)
+		assert.NoError(t, err)
+
+		offsets := db.dataOffset[string(keyHash[:])]
+		record, err := db.RecordAt(offsets.BlockOffset, offsets.RecordOffset)
+		assert.NoError(t, err)
+		assert.Equal(t, keyHash, record.KeyHash)
+
+		var value int
+		err = decode(record.ValueBytes, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+
+	_, err = db.RecordAt(0, 0)
+	assert.Error(t, err)
+
+	_, err = db.RecordAt(blocks[0].Offset+blocks[0].Size, 0)
+	assert.Error(t, err)
+
+	_, err = db.RecordAt(blocks[0].Offset, -1)
+	assert.Error(t, err)
+
+	_, err = db.RecordAt(blocks[0].Offset, 1)
+	assert.Error(t, err)
+}
+
+func BenchmarkSet(b *testing.B) {
+	db, err := OpenWithOptions("BenchmarkSet.zkv", Options{InMemory: true, MemoryBufferSize: 1024 * 1024})
+	assert.NoError(b, err)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("value "), 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = db.Set(i, value)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBufferPreallocation(t *testing.T) {
+	const bufferSize = 64 * 1024
+
+	db, err := OpenWithOptions("TestBufferPreallocation.zkv", Options{InMemory: true, MemoryBufferSize: bufferSize})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, bufferSize, db.buffer.Cap())
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, bufferSize, db.buffer.Cap())
+
+	// Flush keeps buffer capacity
+	err = db.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, db.buffer.Len())
+	assert.Equal(t, bufferSize, db.buffer.Cap())
+}
+
+func TestExportIndex(t *testing.T) {
+	const filePath = "TestExportIndex.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	var index bytes.Buffer
+	err = db.ExportIndex(&index)
+	assert.NoError(t, err)
+	exported := index.Bytes()
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Open with exported index, index file is not read
+	os.Remove(filePath + indexFileExt)
+	db, err = OpenWithOptions(filePath, Options{IndexReader: bytes.NewReader(exported)})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10, db.recordCount)
+
+	for i := 0; i < 10; i++ {
+		var value int
+		err = db.Get(i, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+
+	err = db.Delete(0)
+	assert.NoError(t, err)
+
+	// Index does not match store file after write
+	err = db.ImportIndex(bytes.NewReader(exported))
+	assert.ErrorIs(t, err, ErrIndexMismatch)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	_, err = OpenWithOptions(filePath, Options{IndexReader: bytes.NewReader(exported)})
+	assert.ErrorIs(t, err, ErrIndexMismatch)
+}
+
+func TestRename(t *testing.T) {
+	db, err := OpenWithOptions("TestRename.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Rename(1, 2)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	err = db.Set(1, "one")
+	assert.NoError(t, err)
+	err = db.SetExpireAt(3, "three", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	err = db.Rename(1, 2)
+	assert.NoError(t, err)
+
+	var value string
+	err = db.Get(1, &value)
+	assert.ErrorIs(t, err, ErrNotExists)
+	err = db.Get(2, &value)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", value)
+
+	// Existing key is overwritten, expiration is kept
+	err = db.Rename(3, 2)
+	assert.NoError(t, err)
+	err = db.Get(2, &value)
+	assert.NoError(t, err)
+	assert.Equal(t, "three", value)
+
+	keyHash, err := db.HashKey(2)
+	assert.NoError(t, err)
+	record, err := db.getRecord(keyHash)
+	assert.NoError(t, err)
+	assert.NotZero(t, record.ExpireAt)
+
+	err = db.Rename(2, 2)
+	assert.NoError(t, err)
+	err = db.Get(2, &value)
+	assert.NoError(t, err)
+}
+
+func TestAutoCompact(t *testing.T) {
+	db, err := OpenWithOptions("TestAutoCompact.zkv", Options{InMemory: true, AutoCompact: true, CompactRatioThreshold: 2.5})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// 10 of 20 records are garbage
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i+1)
+		assert.NoError(t, err)
+	}
+	err = db.Flush()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 20, db.recordCount)
+
+	// 15 of 25 records are garbage
+	for i := 0; i < 5; i++ {
+		err = db.Delete(i)
+		assert.NoError(t, err)
+	}
+	err = db.Flush()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, db.recordCount)
+
+	blocks, err := db.Blocks()
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 1)
+
+	for i := 5; i < 10; i++ {
+		var value int
+		err = db.Get(i, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, value)
+	}
+}
+
+func TestIndexCatchUp(t *testing.T) {
+	const filePath = "TestIndexCatchUp.zkv"
+	const customRecordType RecordType = 100
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	err = db.WriteRecord(customRecordType, 0, 0)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	oldIndex, err := os.ReadFile(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	for i := 5; i < 15; i++ {
+		err = db.Set(i, i+1)
+		assert.NoError(t, err)
+	}
+	err = db.Delete(0)
+	assert.NoError(t, err)
+	err = db.WriteRecord(customRecordType, 0, 0)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Index left behind store file by interrupted process
+	err = os.WriteFile(filePath+indexFileExt, oldIndex, 0644)
+	assert.NoError(t, err)
+
+	var handledRecords int
+	db, err = OpenWithOptions(filePath, Options{RecordHandlers: map[RecordType]func(record *Record) error{
+		customRecordType: func(record *Record) error {
+			handledRecords++
+			return nil
+		},
+	}})
+	assert.NoError(t, err)
+
+	// Only records written after index are scanned
+	assert.Equal(t, 1, handledRecords)
+
+	for i := 0; i < 15; i++ {
+		var value int
+		err = db.Get(i, &value)
+		switch {
+		case i == 0:
+			assert.ErrorIs(t, err, ErrNotExists)
+		case i < 5:
+			assert.NoError(t, err)
+			assert.Equal(t, i, value)
+		default:
+			assert.NoError(t, err)
+			assert.Equal(t, i+1, value)
+		}
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestBackupSkipUnchanged(t *testing.T) {
+	const filePath = "TestBackupSkipUnchanged.zkv"
+	const backupFilePath = "TestBackupSkipUnchanged.backup.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(backupFilePath)
+	defer os.Remove(backupFilePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	options := Options{BackupSkipUnchanged: true}
+	err = db.BackupWithOptions(backupFilePath, options)
+	assert.NoError(t, err)
+
+	err = db.Set(0, 100)
+	assert.NoError(t, err)
+	err = db.SetExpireAt(1, 1, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	err = db.BackupWithOptions(backupFilePath, options)
+	assert.NoError(t, err)
+
+	// Only changed value and expiration are written again
+	backup, err := Open(backupFilePath)
+	assert.NoError(t, err)
+	defer backup.Close()
+
+	var setRecords int
+	err = backup.scanRecords(func(_ Offsets, record *Record) error {
+		if record.Type == RecordTypeSet {
+			setRecords++
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 12, setRecords)
+
+	var value int
+	err = backup.Get(0, &value)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, value)
+}
+
+func TestCompactSorted(t *testing.T) {
+	const filePath = "TestCompactSorted.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 100; i += 2 {
+		err = db.Delete(i)
+		assert.NoError(t, err)
+	}
+
+	err = db.CompactSorted()
+	assert.NoError(t, err)
+
+	var keyHashes [][sha256.Size224]byte
+	err = db.scanRecords(func(_ Offsets, record *Record) error {
+		keyHashes = append(keyHashes, record.KeyHash)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, keyHashes, 50)
+	assert.True(t, sort.SliceIsSorted(keyHashes, func(i, j int) bool {
+		return bytes.Compare(keyHashes[i][:], keyHashes[j][:]) < 0
+	}))
+
+	for i := 0; i < 100; i++ {
+		var value int
+		err = db.Get(i, &value)
+		if i%2 == 0 {
+			assert.ErrorIs(t, err, ErrNotExists)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, i, value)
+		}
+	}
+}
+
+func TestExpvarName(t *testing.T) {
+	const filePath = "TestExpvarName.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	options := Options{ExpvarName: "TestExpvarName"}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	var value int
+	err = db.Get(1, &value)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	err = db.Get(1, &value)
+	assert.NoError(t, err)
+
+	err = db.Delete(1)
+	assert.NoError(t, err)
+
+	m := expvar.Get(options.ExpvarName).(*expvar.Map)
+	assert.Equal(t, "1", m.Get("sets").String())
+	assert.Equal(t, "2", m.Get("gets").String())
+	assert.Equal(t, "1", m.Get("deletes").String())
+	assert.Equal(t, "1", m.Get("flushes").String())
+	assert.Equal(t, "1", m.Get("bufferHits").String())
+	assert.NotEqual(t, "0", m.Get("bytesWritten").String())
+	assert.NotEqual(t, "0", m.Get("bytesRead").String())
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Reopened store replaces counters of closed one
+	db, err = OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, "0", m.Get("sets").String())
+}
+
+func TestChunkedValues(t *testing.T) {
+	const filePath = "TestChunkedValues.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	options := Options{MaxValueChunkSize: 100, MemoryBufferSize: 1000}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	value := make([]byte, 10000)
+	rand.Read(value)
+
+	// Streamed value
+	err = db.SetStream(1, bytes.NewReader(value))
+	assert.NoError(t, err)
+
+	rc, err := db.GetStream(1)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.Equal(t, value, b)
+
+	var raw RawValue
+	err = db.Get(1, &raw)
+	assert.NoError(t, err)
+	assert.Equal(t, RawValue(value), raw)
+
+	// Value chunked on Set
+	err = db.Set(2, value)
+	assert.NoError(t, err)
+
+	var got []byte
+	err = db.Get(2, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	// Shorter value deletes extra chunks
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	assert.False(t, db.exists(db.chunkKeyHash([28]byte(mustHashKey(t, db, 1)), 0)))
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Chunks survive index rebuild and compaction
+	os.Remove(filePath + indexFileExt)
+
+	db, err = OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Compact()
+	assert.NoError(t, err)
+
+	got = nil
+	err = db.Get(2, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	stats, err := db.Stats()
+	assert.NoError(t, err)
+	// Encoded value of 10002 bytes takes 101 chunks
+	assert.Equal(t, 2+101, stats.Keys)
+
+	err = db.Delete(2)
+	assert.NoError(t, err)
+
+	stats, err = db.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Keys)
+
+	// Chunks are moved with renamed value
+	err = db.Set(3, value)
+	assert.NoError(t, err)
+	err = db.Rename(3, 4)
+	assert.NoError(t, err)
+
+	got = nil
+	err = db.Get(4, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	_, err = OpenWithOptions("TestChunkedValues.wrong.zkv", Options{MaxValueChunkSize: 1})
+	assert.Error(t, err)
+}
+
+func TestDeleteWhere(t *testing.T) {
+	db, err := OpenWithOptions("TestDeleteWhere.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	deleted, err := db.DeleteWhere(func(keyHash [sha256.Size224]byte, value []byte) (bool, error) {
+		var i int
+		err := decode(value, &i)
+		return i%3 == 0, err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 34, deleted)
+
+	for i := 0; i < 100; i++ {
+		var value int
+		err = db.Get(i, &value)
+		if i%3 == 0 {
+			assert.ErrorIs(t, err, ErrNotExists)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	_, err = db.DeleteWhere(func(keyHash [sha256.Size224]byte, value []byte) (bool, error) {
+		return false, errors.New("stop")
+	})
+	assert.Error(t, err)
+}
+
+func TestKeepWriteHandleOpen(t *testing.T) {
+	const filePath = "TestKeepWriteHandleOpen.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	options := Options{KeepWriteHandleOpen: true}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	err = db.Set(0, 0)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	writeFile := db.writeFile
+	assert.NotNil(t, writeFile)
+
+	for i := 1; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, writeFile, db.writeFile)
+
+	stat, err := os.Stat(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, stat.Size(), db.writeOffset)
+
+	// Replaced store file gets new handle
+	err = db.Compact()
+	assert.NoError(t, err)
+	assert.Nil(t, db.writeFile)
+
+	err = db.Set(10, 10)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+	assert.Nil(t, db.writeFile)
+
+	db, err = OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i <= 10; i++ {
+		var value int
+		err = db.Get(i, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	const filePath = "TestTypeOf.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{StoreTypeNames: true})
+	assert.NoError(t, err)
+
+	err = db.Set(1, []string{"a"})
+	assert.NoError(t, err)
+	err = db.Set(2, map[string]int{"a": 1})
+	assert.NoError(t, err)
+	err = db.Set(3, RawValue{0, 'i', 2})
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Type names are read without option
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	typeName, err := db.TypeOf(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]string", typeName)
+
+	typeName, err = db.TypeOf(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "map[string]int", typeName)
+
+	typeName, err = db.TypeOf(3)
+	assert.NoError(t, err)
+	assert.Empty(t, typeName)
+
+	// Values written without option have no type name
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	typeName, err = db.TypeOf(1)
+	assert.NoError(t, err)
+	assert.Empty(t, typeName)
+
+	_, err = db.TypeOf(4)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestBufferedValueNotAliased(t *testing.T) {
+	const filePath = "TestBufferedValueNotAliased.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Set(1, []byte("value"))
+	assert.NoError(t, err)
+
+	db.mu.RLock()
+	b, err := db.get(1)
+	db.mu.RUnlock()
+	assert.NoError(t, err)
+	expected := append([]byte(nil), b...)
+
+	var raw RawValue
+	err = db.Get(1, &raw)
+	assert.NoError(t, err)
+
+	// Flush resets buffer, new records overwrite its memory
+	err = db.Flush()
+	assert.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		err = db.Set(i, []byte("other value"))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, expected, b)
+	assert.Equal(t, RawValue(expected), raw)
+}
+
+func TestBackupSnapshot(t *testing.T) {
+	const filePath = "TestBackupSnapshot.zkv"
+	const backupFilePath = "TestBackupSnapshot.backup.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(backupFilePath)
+	defer os.Remove(backupFilePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	var (
+		started  = make(chan struct{})
+		proceed  = make(chan struct{})
+		backupOk = make(chan error)
+	)
+	go func() {
+		var once sync.Once
+		backupOk <- db.BackupFiltered(backupFilePath, Options{}, func(keyHash [sha256.Size224]byte, value []byte) (bool, error) {
+			once.Do(func() {
+				close(started)
+				<-proceed
+			})
+			return true, nil
+		})
+	}()
+	<-started
+
+	// Writes are not blocked by backup
+	err = db.Set(100, 100)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	// Compaction waits for backup
+	compactOk := make(chan error)
+	go func() { compactOk <- db.Compact() }()
+	select {
+	case <-compactOk:
+		t.Fatal("store file compacted during backup")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(proceed)
+	assert.NoError(t, <-backupOk)
+	assert.NoError(t, <-compactOk)
+
+	backup, err := Open(backupFilePath)
+	assert.NoError(t, err)
+	defer backup.Close()
+
+	for i := 0; i < 100; i++ {
+		var value int
+		err = backup.Get(i, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+
+	var value int
+	err = backup.Get(100, &value)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestHistoryWithTime(t *testing.T) {
+	const filePath = "TestHistoryWithTime.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{StoreWriteTimes: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	start := time.Now()
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+	err = db.Delete(1)
+	assert.NoError(t, err)
+	err = db.Set(1, 3)
+	assert.NoError(t, err)
+
+	versions, err := db.HistoryWithTime(1)
+	assert.NoError(t, err)
+	assert.Len(t, versions, 3)
+
+	expected := []struct {
+		value   interface{}
+		deleted bool
+	}{{1, false}, {nil, true}, {3, false}}
+	for i, version := range versions {
+		assert.Equal(t, expected[i].deleted, version.Deleted)
+		if !version.Deleted {
+			var value int
+			err = decode(version.Value, &value)
+			assert.NoError(t, err)
+			assert.Equal(t, expected[i].value, value)
+		}
+
+		assert.False(t, version.WrittenAt.Before(start))
+		if i > 0 {
+			assert.False(t, version.WrittenAt.Before(versions[i-1].WrittenAt))
+		}
+	}
+
+	versions, err = db.HistoryWithTime(3)
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+}
+
+func TestEmptyFlush(t *testing.T) {
+	const filePath = "TestEmptyFlush.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	// Store without writes creates no file
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	err = db.Flush()
+	assert.NoError(t, err)
+	err = db.Close()
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filePath)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	stat, err := os.Stat(filePath)
+	assert.NoError(t, err)
+
+	// Empty flush does not touch file
+	time.Sleep(10 * time.Millisecond)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	stat2, err := os.Stat(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, stat.ModTime(), stat2.ModTime())
+
+	// Compaction of store without live records removes file
+	err = db.Delete(1)
+	assert.NoError(t, err)
+	err = db.Compact()
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filePath)
+
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+
+	var value int
+	err = db.Get(2, &value)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+}
+
+func TestWriteIndexTo(t *testing.T) {
+	const filePath = "TestWriteIndexTo.zkv"
+	const indexPath = "TestWriteIndexTo.copy.idx"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(indexPath)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.WriteIndexTo(indexPath)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Index copy replaces lost index file
+	os.Remove(filePath + indexFileExt)
+
+	f, err := os.Open(indexPath)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	db, err = OpenWithOptions(filePath, Options{IndexReader: f})
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.EqualValues(t, 10, db.recordCount)
+
+	for i := 0; i < 10; i++ {
+		var value int
+		err = db.Get(i, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+
+	err = db.WriteIndexTo(filepath.Join("not exists", indexPath))
+	assert.Error(t, err)
+}
+
+func TestTailTo(t *testing.T) {
+	const filePath = "TestTailTo.zkv"
+	const replicaFilePath = "TestTailTo.replica.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(replicaFilePath)
+	defer os.Remove(replicaFilePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Set(0, 0)
+	assert.NoError(t, err)
+
+	// Existing blocks are written first
+	var replica bytes.Buffer
+	stop, err := db.TailTo(&replica)
+	assert.NoError(t, err)
+
+	for i := 1; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+
+	// Stop waits for queued blocks, stopped tail receives nothing
+	stop()
+
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, b, replica.Bytes())
+
+	err = os.WriteFile(replicaFilePath, replica.Bytes(), 0644)
+	assert.NoError(t, err)
+
+	replicaDB, err := Open(replicaFilePath)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		var value int
+		err = replicaDB.Get(i, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+	assert.NoError(t, replicaDB.Close())
+
+	err = db.Set(10, 10)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, b, replica.Bytes())
+
+	// Compaction stops tail
+	_, err = db.TailTo(io.Discard)
+	assert.NoError(t, err)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+	assert.Error(t, <-db.Errors())
+}
+
+// blockingWriter blocks writes until release is closed
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w blockingWriter) Write(b []byte) (int, error) {
+	<-w.release
+
+	return len(b), nil
+}
+
+func TestTailToSlowWriter(t *testing.T) {
+	db, err := OpenWithOptions("TestTailToSlowWriter.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	w := blockingWriter{release: make(chan struct{})}
+	stop, err := db.TailTo(w)
+	assert.NoError(t, err)
+
+	// Flushes are not blocked by writer, tail which falls behind
+	// is stopped
+	for i := 0; i < tailQueueSize+2; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+		err = db.Flush()
+		assert.NoError(t, err)
+	}
+	assert.ErrorIs(t, <-db.Errors(), errTailQueueFull)
+	assert.Empty(t, db.tails)
+
+	close(w.release)
+	stop()
+}
+
+func TestFormatVersionLegacyCompact(t *testing.T) {
+	const filePath = "TestFormatVersionLegacyCompact.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	b, err := os.ReadFile("testdata/TestReadBlock.zkv")
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersionLegacy, db.version)
+
+	err = db.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, db.version)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	b, err = os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, b[0])
+
+	err = os.Remove(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, db.version)
+	assert.Len(t, db.dataOffset, 4)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestGetInto(t *testing.T) {
+	db, err := OpenWithOptions("TestGetInto.zkv", Options{InMemory: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	long := bytes.Repeat([]byte("value "), 100)
+
+	err = db.Set(1, []byte("short"))
+	assert.NoError(t, err)
+	err = db.Set(2, long)
+	assert.NoError(t, err)
+	err = db.Set(3, [4]byte{1, 2, 3, 4})
+	assert.NoError(t, err)
+	err = db.Set(4, []byte{})
+	assert.NoError(t, err)
+	err = db.Set(5, "string")
+	assert.NoError(t, err)
+
+	buf := make([]byte, 0, 1024)
+
+	got, err := db.GetInto(1, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("short"), got)
+	assert.Equal(t, &buf[:1][0], &got[0])
+
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	got, err = db.GetInto(2, got)
+	assert.NoError(t, err)
+	assert.Equal(t, long, got)
+	assert.Equal(t, &buf[:1][0], &got[0])
+
+	got, err = db.GetInto(3, got)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, got)
+
+	got, err = db.GetInto(4, got)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	// Too small buffer is grown
+	got, err = db.GetInto(2, make([]byte, 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, long, got)
+
+	_, err = db.GetInto(5, buf)
+	assert.Error(t, err)
+
+	_, err = db.GetInto(6, buf)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func BenchmarkGetInto(b *testing.B) {
+	const keyCount = 1000
+
+	db, err := OpenWithOptions("BenchmarkGetInto.zkv", Options{InMemory: true})
+	assert.NoError(b, err)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("value "), 100)
+	for i := 0; i < keyCount; i++ {
+		err = db.Set(i, value)
+		assert.NoError(b, err)
+	}
+	err = db.Flush()
+	assert.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf, err = db.GetInto(i%keyCount, buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMaxBufferedKeys(t *testing.T) {
+	db, err := OpenWithOptions("TestMaxBufferedKeys.zkv", Options{InMemory: true, MaxBufferedKeys: 10})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, db.bufferDataOffset, 10)
+	assert.Empty(t, db.dataOffset)
+
+	// Overwrite of buffered key does not add key
+	err = db.Set(0, 1)
+	assert.NoError(t, err)
+	assert.Len(t, db.bufferDataOffset, 10)
+
+	err = db.Set(10, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, db.bufferDataOffset)
+	assert.Len(t, db.dataOffset, 11)
+
+	var got int
+	err = db.Get(10, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, got)
+}
+
+func TestTailOffset(t *testing.T) {
+	const filePath = "TestTailOffset.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	offset, err := db.TailOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+
+	offset, err = db.TailOffset()
+	assert.NoError(t, err)
+
+	// Unflushed records do not move offset
+	err = db.Set(2, 2)
+	assert.NoError(t, err)
+
+	got, err := db.TailOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, offset, got)
+
+	keyHashes, err := db.KeysSince(offset)
+	assert.NoError(t, err)
+	assert.Empty(t, keyHashes)
+
+	// Tail resumed from offset receives only following blocks
+	var replica bytes.Buffer
+	stop, err := db.TailFrom(&replica, offset)
+	assert.NoError(t, err)
+
+	err = db.Set(3, 3)
+	assert.NoError(t, err)
+	err = db.Flush()
+	assert.NoError(t, err)
+	stop()
+
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, b[offset:], replica.Bytes())
+
+	keyHashes, err = db.KeysSince(offset)
+	assert.NoError(t, err)
+	assert.Len(t, keyHashes, 2)
+
+	_, err = db.TailFrom(io.Discard, int64(len(b))+1)
+	assert.Error(t, err)
+}
+
+func TestCorruptIndexRebuild(t *testing.T) {
+	const filePath = "TestCorruptIndexRebuild.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := Open(filePath)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Truncated index as written by crash during index save
+	b, err := os.ReadFile(filePath + indexFileExt)
+	assert.NoError(t, err)
+	err = os.WriteFile(filePath+indexFileExt, b[:len(b)/2], 0644)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, <-db.Errors(), ErrCorruptIndex)
+	assert.Len(t, db.dataOffset, 100)
+
+	for i := 0; i < 100; i++ {
+		var got int
+		err = db.Get(i, &got)
+		assert.NoError(t, err)
+		assert.Equal(t, i, got)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Garbage index
+	err = os.WriteFile(filePath+indexFileExt, []byte("garbage"), 0644)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	assert.Len(t, db.dataOffset, 100)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestSchemaVersion(t *testing.T) {
+	const filePath = "TestSchemaVersion.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{SchemaVersion: 2})
+	assert.NoError(t, err)
+
+	version, err := db.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), version)
+
+	err = db.Set(1, 1)
+	assert.NoError(t, err)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Newer schema version of store
+	_, err = OpenWithOptions(filePath, Options{SchemaVersion: 1})
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+
+	// Older schema version of store is kept
+	db, err = OpenWithOptions(filePath, Options{SchemaVersion: 3})
+	assert.NoError(t, err)
+
+	version, err = db.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), version)
+
+	err = db.SetMetadata(map[string]string{"a": "b"})
+	assert.NoError(t, err)
+
+	err = db.SetSchemaVersion(3)
+	assert.NoError(t, err)
+
+	metadata, err := db.GetMetadata()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", metadata["a"])
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Store opened without option keeps schema version
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+
+	version, err = db.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), version)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	_, err = OpenWithOptions(filePath, Options{SchemaVersion: 2})
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
+
+func TestEstimateSize(t *testing.T) {
+	type Foo struct {
+		A int
+		B string
+	}
+
+	for _, options := range []Options{{InMemory: true}, {InMemory: true, StoreTypeNames: true, StoreWriteTimes: true}} {
+		db, err := OpenWithOptions("TestEstimateSize.zkv", options)
+		assert.NoError(t, err)
+
+		for i, value := range []interface{}{1, "value", bytes.Repeat([]byte{1}, 1000), Foo{A: 1, B: "b"}} {
+			size, err := db.EstimateSize(value)
+			assert.NoError(t, err)
+
+			bufferSize := db.buffer.Len()
+			err = db.Set(i, value)
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, db.buffer.Len()-bufferSize, size)
+			assert.Greater(t, db.buffer.Len()-bufferSize, size-sha256.Size224)
+		}
+
+		assert.NoError(t, db.Close())
+	}
+}
+
+func TestIntegrityManifest(t *testing.T) {
+	const filePath = "TestIntegrityManifest.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+	defer os.Remove(filePath + manifestFileExt)
+
+	options := Options{IntegrityManifest: true}
+
+	db, err := OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Set(i, i)
+		assert.NoError(t, err)
+	}
+
+	err = db.Close()
+	assert.NoError(t, err)
+	assert.FileExists(t, filePath+manifestFileExt)
+
+	// Verified manifest is removed on open
+	db, err = OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filePath+manifestFileExt)
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	// Damaged byte of block
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+
+	_, err = OpenWithOptions(filePath, options)
+	assert.ErrorIs(t, err, ErrIntegrity)
+
+	// Truncated file
+	err = os.WriteFile(filePath, b[:len(b)-1], 0644)
+	assert.NoError(t, err)
+
+	_, err = OpenWithOptions(filePath, options)
+	assert.ErrorIs(t, err, ErrIntegrity)
+
+	// Store is opened without manifest
+	b[len(b)-1] ^= 0xff
+	err = os.WriteFile(filePath, b, 0644)
+	assert.NoError(t, err)
+	err = os.Remove(filePath + manifestFileExt)
+	assert.NoError(t, err)
+
+	db, err = OpenWithOptions(filePath, options)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+}
+
+func TestGetByHash(t *testing.T) {
+	for _, keyHashBits := range []int{128, 224} {
+		db, err := OpenWithOptions("TestGetByHash.zkv", Options{InMemory: true, KeyHashBits: keyHashBits})
+		assert.NoError(t, err)
+
+		err = db.Set("key", 1)
+		assert.NoError(t, err)
+
+		keyHash, err := HashKey("key")
+		assert.NoError(t, err)
+
+		for _, s := range []string{
+			hex.EncodeToString(keyHash[:]),
+			base64.StdEncoding.EncodeToString(keyHash[:]),
+			base64.RawURLEncoding.EncodeToString(keyHash[:]),
+			hex.EncodeToString(keyHash[:16]),
+		} {
+			parsed, err := ParseKeyHash(s)
+			assert.NoError(t, err)
+
+			var got int
+			err = db.GetByHash(parsed, &got)
+			if keyHashBits == 224 && len(s) == 32 {
+				assert.ErrorIs(t, err, ErrNotExists)
+				continue
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 1, got)
+		}
+
+		var got int
+		err = db.GetByHash([sha256.Size224]byte{1}, &got)
+		assert.ErrorIs(t, err, ErrNotExists)
+
+		err = db.GetByHash(keyHash, got)
+		assert.ErrorIs(t, err, ErrValueNotPointer)
+
+		assert.NoError(t, db.Close())
+	}
+
+	_, err := ParseKeyHash("0102")
+	assert.Error(t, err)
+
+	_, err = ParseKeyHash("not a hash")
+	assert.Error(t, err)
+}
+
+func TestDedupBufferWrites(t *testing.T) {
+	const filePath = "TestDedupBufferWrites.zkv"
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + indexFileExt)
+
+	db, err := OpenWithOptions(filePath, Options{DedupBufferWrites: true})
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Set(i%10, i)
+		assert.NoError(t, err)
+	}
+	err = db.Delete(0)
+	assert.NoError(t, err)
+	err = db.Set(1, "value")
+	assert.NoError(t, err)
+
+	// Buffer holds only the latest records of keys, overwritten records
+	// are dropped on flush
+	assert.Equal(t, 11, db.bufferRecords)
+	assert.Len(t, db.staleBufferOffsets, 91)
+	assert.NoError(t, db.checkConsistency())
+
+	err = db.Flush()
+	assert.NoError(t, err)
+	assert.Empty(t, db.staleBufferOffsets)
+	assert.Equal(t, 11, db.blockStats.MaxRecords)
+
+	check := func() {
+		var got int
+		err = db.Get(0, &got)
+		assert.ErrorIs(t, err, ErrNotExists)
+
+		var s string
+		err = db.Get(1, &s)
+		assert.NoError(t, err)
+		assert.Equal(t, "value", s)
+
+		for i := 2; i < 10; i++ {
+			err = db.Get(i, &got)
+			assert.NoError(t, err)
+			assert.Equal(t, 90+i, got)
+		}
+	}
+	check()
+
+	err = db.Close()
+	assert.NoError(t, err)
+
+	err = os.Remove(filePath + indexFileExt)
+	assert.NoError(t, err)
+
+	db, err = Open(filePath)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	check()
+}
+
+func TestDedupBufferWritesOrder(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		db, err := OpenWithOptions("TestDedupBufferWritesOrder.zkv", Options{InMemory: true, DedupBufferWrites: dedup})
+		assert.NoError(t, err)
+
+		for _, key := range []string{"a", "b", "a"} {
+			err = db.Set(key, key)
+			assert.NoError(t, err)
+		}
+
+		var keys []string
+		err = db.ForEachOrdered(func(keyHash [28]byte, value []byte) error {
+			var v string
+			err := decode(value, &v)
+			keys = append(keys, v)
+
+			return err
+		})
+		assert.NoError(t, err)
+
+		// Deduplicated key moves to position of its latest write
+		if dedup {
+			assert.Equal(t, []string{"b", "a"}, keys)
+		} else {
+			assert.Equal(t, []string{"a", "b"}, keys)
+		}
+
+		err = db.Close()
+		assert.NoError(t, err)
+	}
+}